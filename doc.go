@@ -6,7 +6,8 @@
 // For nodes, it creates virtual machines by importing pre-packaged
 // OVA files, maintained by the companion driver-vbox-images project.
 // For images, it uses the aforesaid OVA files, downloading the list
-// from the URL pointed to by the ImagesSourceURL variable.
+// from the URL pointed to by the ImagesSourceURL variable, or from the
+// mirrors listed in the ImageSources variable.
 //
 // The details of individual operations can be found in the online
 // documentation. Details about the interface between the driver and
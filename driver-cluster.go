@@ -0,0 +1,248 @@
+package drivervbox
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/kuttiproject/kuttilog"
+)
+
+// DefaultClusterConcurrency is the number of machines operated on at the same
+// time by cluster-level operations, if not specified in ClusterOptions.
+var DefaultClusterConcurrency = 4
+
+// ClusterOptions control cluster-level operations on all machines in a
+// cluster. A nil *ClusterOptions is the same as the zero value.
+type ClusterOptions struct {
+	// MaxConcurrency is the maximum number of machines operated on at the
+	// same time. Zero means DefaultClusterConcurrency.
+	MaxConcurrency int
+	// PriorityMachines are names of machines, such as control plane nodes,
+	// that are started before all others, and stopped or deleted after
+	// all others.
+	PriorityMachines []string
+	// StopTimeout is the number of seconds StopCluster waits for each
+	// machine to shut down gracefully before forcing it. See StopAndWait.
+	StopTimeout int
+}
+
+// ClusterError is returned by cluster-level operations when operations on
+// one or more machines fail. Operations on other machines are not affected.
+type ClusterError struct {
+	Operation   string
+	ClusterName string
+	// MachineErrors maps machine names to errors.
+	MachineErrors map[string]error
+}
+
+func (ce *ClusterError) Error() string {
+	names := make([]string, 0, len(ce.MachineErrors))
+	for name := range ce.MachineErrors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	messages := make([]string, len(names))
+	for i, name := range names {
+		messages[i] = fmt.Sprintf("%s: %v", name, ce.MachineErrors[name])
+	}
+
+	return fmt.Sprintf(
+		"could not %s %d machine(s) in cluster %s: %s",
+		ce.Operation,
+		len(names),
+		ce.ClusterName,
+		strings.Join(messages, "; "),
+	)
+}
+
+// clustermachines returns all machines in the VirtualBox group of a cluster.
+func (vd *Driver) clustermachines(clustername string) ([]*Machine, error) {
+	qualifiednames, err := vd.clustermachinenames(clustername)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := vd.QualifiedMachineName("", clustername)
+	result := make([]*Machine, len(qualifiednames))
+	for i, qualifiedname := range qualifiednames {
+		result[i] = &Machine{
+			driver:      vd,
+			name:        strings.TrimPrefix(qualifiedname, prefix),
+			clustername: clustername,
+		}
+	}
+
+	return result, nil
+}
+
+// clusteroperation runs an operation on all machines in a cluster, in two
+// stages: priority machines and the rest. If prioritylast is true, the
+// priority machines are done in the second stage.
+func (vd *Driver) clusteroperation(clustername string, operation string, options *ClusterOptions, prioritylast bool, op func(*Machine) error) error {
+	if !vd.validate() {
+		return vd
+	}
+
+	if options == nil {
+		options = &ClusterOptions{}
+	}
+
+	machines, err := vd.clustermachines(clustername)
+	if err != nil {
+		return err
+	}
+
+	return runclusteroperation(machines, clustername, operation, options, prioritylast, op)
+}
+
+// runclusteroperation runs an operation on the specified machines, as
+// described in clusteroperation.
+func runclusteroperation(machines []*Machine, clustername string, operation string, options *ClusterOptions, prioritylast bool, op func(*Machine) error) error {
+	concurrency := options.MaxConcurrency
+	if concurrency <= 0 {
+		concurrency = DefaultClusterConcurrency
+	}
+
+	priority := map[string]bool{}
+	for _, name := range options.PriorityMachines {
+		priority[name] = true
+	}
+
+	var prioritymachines, othermachines []*Machine
+	for _, machine := range machines {
+		if priority[machine.name] {
+			prioritymachines = append(prioritymachines, machine)
+		} else {
+			othermachines = append(othermachines, machine)
+		}
+	}
+
+	stages := [][]*Machine{prioritymachines, othermachines}
+	if prioritylast {
+		stages = [][]*Machine{othermachines, prioritymachines}
+	}
+
+	clustererr := &ClusterError{
+		Operation:     operation,
+		ClusterName:   clustername,
+		MachineErrors: map[string]error{},
+	}
+
+	var mu sync.Mutex
+	for _, stage := range stages {
+		var wg sync.WaitGroup
+		sem := make(chan struct{}, concurrency)
+
+		for _, machine := range stage {
+			wg.Add(1)
+			sem <- struct{}{}
+
+			go func(vh *Machine) {
+				defer wg.Done()
+				defer func() { <-sem }()
+
+				err := op(vh)
+				if err != nil {
+					mu.Lock()
+					clustererr.MachineErrors[vh.name] = err
+					mu.Unlock()
+				}
+			}(machine)
+		}
+
+		wg.Wait()
+	}
+
+	if len(clustererr.MachineErrors) != 0 {
+		return clustererr
+	}
+
+	return nil
+}
+
+// StartCluster starts all machines in a cluster. Priority machines are
+// started first. See Machine.Start for details.
+// Machines that are already running are skipped, and paused machines are
+// resumed. So, StartCluster can safely be called again if it fails partway.
+// If any machines fail to start, a *ClusterError is returned.
+func (vd *Driver) StartCluster(clustername string, options *ClusterOptions) error {
+	return vd.clusteroperation(clustername, "start", options, false, func(vh *Machine) error {
+		vmstate, err := vh.vmstate()
+		if err != nil {
+			return err
+		}
+
+		switch vmstate {
+		case vmStateRunning:
+			kuttilog.Printf(kuttilog.Debug, "Host %s is already running. Skipping.", vh.name)
+			return nil
+		case vmStatePaused:
+			return vh.Resume()
+		}
+
+		return vh.Start()
+	})
+}
+
+// StopCluster stops all machines in a cluster, waiting for each to shut down.
+// Priority machines are stopped last. See Machine.StopAndWait for details.
+// If any machines fail to stop, a *ClusterError is returned.
+func (vd *Driver) StopCluster(clustername string, options *ClusterOptions) error {
+	timeout := 60
+	if options != nil && options.StopTimeout > 0 {
+		timeout = options.StopTimeout
+	}
+
+	return vd.clusteroperation(clustername, "stop", options, true, func(vh *Machine) error {
+		_, err := vh.StopAndWait(timeout)
+		return err
+	})
+}
+
+// SnapshotCluster takes a snapshot with the specified name of all machines in
+// a cluster. See Machine.TakeSnapshot for details.
+// If any snapshots fail, a *ClusterError is returned.
+func (vd *Driver) SnapshotCluster(clustername string, snapshotname string, options *ClusterOptions) error {
+	return vd.clusteroperation(clustername, "snapshot", options, false, func(vh *Machine) error {
+		return vh.TakeSnapshot(snapshotname)
+	})
+}
+
+// deletePowerOffTimeout is the number of seconds DeleteCluster waits for each
+// running machine to power off before deleting it.
+const deletePowerOffTimeout = 30
+
+// DeleteCluster deletes all machines in a cluster, and then the cluster
+// network. Priority machines are deleted last. Running machines are powered
+// off first, and each is deleted only once VirtualBox reports it as powered
+// off. The network is deleted only after all machines have been
+// deleted; see DeleteNetwork.
+// If any machines fail to be deleted, a *ClusterError is returned, and the
+// network is kept, so that DeleteCluster can safely be called again.
+func (vd *Driver) DeleteCluster(clustername string, options *ClusterOptions) error {
+	err := vd.clusteroperation(clustername, "delete", options, true, func(vh *Machine) error {
+		vmstate, err := vh.vmstate()
+		if err == nil && (vmstate == vmStateRunning || vmstate == vmStatePaused) {
+			err = vh.ForceStop()
+			if err != nil {
+				return err
+			}
+
+			// The session lock may not have been released yet
+			err = vh.waitforpoweroff(deletePowerOffTimeout)
+			if err != nil {
+				return err
+			}
+		}
+
+		return vd.DeleteMachine(vh.name, clustername)
+	})
+	if err != nil {
+		return err
+	}
+
+	return vd.DeleteNetwork(clustername)
+}
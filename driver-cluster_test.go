@@ -0,0 +1,108 @@
+package drivervbox
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testclustermachines(names ...string) []*Machine {
+	result := make([]*Machine, len(names))
+	for i, name := range names {
+		result[i] = &Machine{driver: &Driver{}, name: name, clustername: "zang"}
+	}
+	return result
+}
+
+func TestClusterOperationOrder(t *testing.T) {
+	machines := testclustermachines("worker1", "control1", "worker2", "worker3")
+	options := &ClusterOptions{MaxConcurrency: 2, PriorityMachines: []string{"control1"}}
+
+	for _, prioritylast := range []bool{false, true} {
+		var mu sync.Mutex
+		order := []string{}
+		err := runclusteroperation(machines, "zang", "test", options, prioritylast, func(vh *Machine) error {
+			mu.Lock()
+			order = append(order, vh.name)
+			mu.Unlock()
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(order) != len(machines) {
+			t.Fatalf("operation ran on %d machines; want %d", len(order), len(machines))
+		}
+
+		priorityindex := 0
+		if prioritylast {
+			priorityindex = len(order) - 1
+		}
+		if order[priorityindex] != "control1" {
+			t.Errorf("prioritylast %v: priority machine not at position %d: %v", prioritylast, priorityindex, order)
+		}
+	}
+}
+
+func TestClusterOperationConcurrency(t *testing.T) {
+	machines := testclustermachines("node1", "node2", "node3", "node4", "node5", "node6")
+	options := &ClusterOptions{MaxConcurrency: 2}
+
+	var running, maxrunning int32
+	err := runclusteroperation(machines, "zang", "test", options, false, func(vh *Machine) error {
+		current := atomic.AddInt32(&running, 1)
+		for {
+			max := atomic.LoadInt32(&maxrunning)
+			if current <= max || atomic.CompareAndSwapInt32(&maxrunning, max, current) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if maxrunning > 2 {
+		t.Errorf("%d operations ran concurrently; want at most 2", maxrunning)
+	}
+}
+
+func TestClusterOperationErrors(t *testing.T) {
+	machines := testclustermachines("node1", "node2", "node3")
+
+	ran := int32(0)
+	err := runclusteroperation(machines, "zang", "start", &ClusterOptions{}, false, func(vh *Machine) error {
+		atomic.AddInt32(&ran, 1)
+		if vh.name == "node2" || vh.name == "node3" {
+			return errors.New("failed " + vh.name)
+		}
+		return nil
+	})
+
+	if ran != 3 {
+		t.Errorf("operation ran on %d machines; want all 3 despite failures", ran)
+	}
+
+	clustererr, ok := err.(*ClusterError)
+	if !ok {
+		t.Fatalf("error %v is not a *ClusterError", err)
+	}
+	if len(clustererr.MachineErrors) != 2 || clustererr.MachineErrors["node1"] != nil {
+		t.Errorf("unexpected machine errors: %v", clustererr.MachineErrors)
+	}
+
+	expected := "could not start 2 machine(s) in cluster zang: node2: failed node2; node3: failed node3"
+	if clustererr.Error() != expected {
+		t.Errorf("unexpected message:\n got %q\nwant %q", clustererr.Error(), expected)
+	}
+
+	if err := runclusteroperation(nil, "zang", "start", &ClusterOptions{}, false, nil); err != nil {
+		t.Errorf("operation on empty cluster failed: %v", err)
+	}
+}
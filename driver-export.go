@@ -0,0 +1,363 @@
+package drivervbox
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kuttiproject/kuttilog"
+	"github.com/kuttiproject/workspace"
+)
+
+// Keys of kutti product properties stored in exported OVA files. The
+// Kubernetes version is stored in the version field of the product section.
+const (
+	ovfKeyCluster       = "kutti.cluster"
+	ovfKeyMachine       = "kutti.machine"
+	ovfKeySavedIP       = "kutti.savedipaddress"
+	ovfKeyForwards      = "kutti.forwards"
+	ovfKeyImageChecksum = "kutti.imagechecksum"
+	ovfKeyDriverVersion = "kutti.driverversion"
+	ovfKeyCreationTime  = "kutti.creationtime"
+	ovfKeyRole          = "kutti.role"
+	ovfProduct          = "kutti"
+	ovfVendor           = "kuttiproject"
+)
+
+// ExportOptions control how machines are exported.
+// A nil *ExportOptions is the same as the zero value.
+type ExportOptions struct {
+	// K8sVersion is recorded as the product version of each exported machine.
+	// If empty, the version recorded when each machine was created is used.
+	K8sVersion string
+}
+
+// ExportMachine exports a single machine to an OVA file.
+// See ExportCluster for details.
+func (vd *Driver) ExportMachine(machinename string, clustername string, ovafile string, options *ExportOptions) error {
+	if !vd.validate() {
+		return vd
+	}
+
+	machine := &Machine{
+		driver:      vd,
+		name:        machinename,
+		clustername: clustername,
+	}
+
+	return vd.export([]*Machine{machine}, clustername, ovafile, options)
+}
+
+// ExportCluster exports all machines in a cluster to a single OVA file.
+// It does this by running the command:
+//   VBoxManage export <machinename>... --output <ovafile> --vsys <n> --product kutti --vendor kuttiproject --version <k8sversion>...
+// The cluster name, machine name, saved IP address, port forwarding rules and
+// creation details of each machine are then added to its product section as
+// OVF product properties, so that ImportCluster can recreate the cluster.
+// Machines should be stopped before exporting.
+func (vd *Driver) ExportCluster(clustername string, ovafile string, options *ExportOptions) error {
+	if !vd.validate() {
+		return vd
+	}
+
+	machines, err := vd.clustermachines(clustername)
+	if err != nil {
+		return err
+	}
+	if len(machines) == 0 {
+		return fmt.Errorf("no machines found in cluster %s", clustername)
+	}
+
+	return vd.export(machines, clustername, ovafile, options)
+}
+
+func (vd *Driver) export(machines []*Machine, clustername string, ovafile string, options *ExportOptions) error {
+	if options == nil {
+		options = &ExportOptions{}
+	}
+
+	// Port forwarding rules are only available from the network
+	var rules []PortForwardRule
+	network, err := vd.GetNetwork(clustername)
+	if err == nil {
+		rules = network.PortForwards()
+	} else {
+		kuttilog.Printf(kuttilog.Info, "Could not read network for cluster %s. Port forwarding rules will not be exported.", clustername)
+	}
+
+	params := []string{"export"}
+	for _, machine := range machines {
+		params = append(params, machine.qname())
+	}
+	params = append(params, "--output", ovafile)
+
+	properties := make([][]ovfproperty, len(machines))
+	for index, machine := range machines {
+		err = machine.get()
+		if err != nil {
+			return err
+		}
+
+		// Default to the version recorded when the machine was created
+		k8sversion := options.K8sVersion
+		if k8sversion == "" {
+			k8sversion = machine.info.k8sversion
+		}
+
+		properties[index] = machineovfproperties(machine, rules)
+
+		vsys := strconv.Itoa(index)
+		params = append(
+			params,
+			"--vsys", vsys, "--product", ovfProduct,
+			"--vsys", vsys, "--vendor", ovfVendor,
+		)
+		if k8sversion != "" {
+			params = append(params, "--vsys", vsys, "--version", k8sversion)
+		}
+	}
+
+	kuttilog.Printf(kuttilog.Info, "Exporting %d machine(s) to %s...", len(machines), ovafile)
+	output, err := workspace.RunWithResults(
+		vd.vboxmanagepath,
+		params...,
+	)
+	if err != nil {
+		return fmt.Errorf("could not export to %s: %v:%s", ovafile, err, output)
+	}
+
+	kuttilog.Println(kuttilog.Info, "Adding kutti properties...")
+	err = rewriteovf(ovafile, func(descriptor []byte) ([]byte, error) {
+		return insertovfproperties(descriptor, properties)
+	})
+	if err != nil {
+		return fmt.Errorf("could not add kutti properties to %s: %v", ovafile, err)
+	}
+
+	return nil
+}
+
+// machineovfproperties returns the product properties that describe
+// a machine in an exported OVA file.
+func machineovfproperties(machine *Machine, rules []PortForwardRule) []ovfproperty {
+	result := []ovfproperty{
+		{Key: ovfKeyCluster, Value: machine.clustername},
+		{Key: ovfKeyMachine, Value: machine.name},
+	}
+
+	optional := []ovfproperty{
+		{Key: ovfKeySavedIP, Value: machine.savedipaddress},
+		{Key: ovfKeyImageChecksum, Value: machine.info.imagechecksum},
+		{Key: ovfKeyDriverVersion, Value: machine.info.driverversion},
+		{Key: ovfKeyRole, Value: machine.info.role},
+	}
+	if !machine.info.creationtime.IsZero() {
+		optional = append(optional, ovfproperty{Key: ovfKeyCreationTime, Value: machine.info.creationtime.Format(time.RFC3339)})
+	}
+
+	forwards := []string{}
+	for _, rule := range rules {
+		if rule.IPv6 || rule.Name != machine.forwardingrulename(rule.GuestPort) {
+			continue
+		}
+		forwards = append(forwards, fmt.Sprintf("%d:%d", rule.HostPort, rule.GuestPort))
+	}
+	optional = append(optional, ovfproperty{Key: ovfKeyForwards, Value: strings.Join(forwards, ",")})
+
+	for _, property := range optional {
+		if property.Value != "" {
+			result = append(result, property)
+		}
+	}
+
+	return result
+}
+
+// ImportCluster imports machines from an OVA file created by ExportCluster or
+// ExportMachine, and recreates their cluster network and port forwarding rules.
+// If clustername is empty, the cluster name recorded in the file is used.
+// It does this by running the command:
+//   VBoxManage import <ovafile> --vsys <n> --vmname <machinename> --group /<clustername> --basefolder <dir>...
+// and then, for each machine, attaching it to the network as NewMachine does, and running:
+//   VBoxManage dhcpserver modify --netname <networkname> --vm <machinename> --nic 1 --fixed-address <savedipaddress>
+// so that the machine keeps its saved IP address. The creation details of each
+// machine, such as the Kubernetes version of its image, are restored as guest
+// properties under /kutti/VMInfo/.
+func (vd *Driver) ImportCluster(ovafile string, clustername string) error {
+	if !vd.validate() {
+		return vd
+	}
+
+	envelope, err := readovf(ovafile)
+	if err != nil {
+		return fmt.Errorf("could not read %s: %v", ovafile, err)
+	}
+
+	systems := envelope.virtualsystems()
+	if len(systems) == 0 {
+		return fmt.Errorf("no machines found in %s", ovafile)
+	}
+
+	metadata := make([]map[string]string, len(systems))
+	for index, system := range systems {
+		metadata[index] = system.Product.properties()
+		if clustername == "" {
+			clustername = metadata[index][ovfKeyCluster]
+		}
+	}
+	if clustername == "" {
+		return errors.New("no cluster name specified, and none found in " + ovafile)
+	}
+
+	_, err = vd.NewNetwork(clustername)
+	if err != nil {
+		return err
+	}
+
+	machinebasedir, err := machinesBaseDir()
+	if err != nil {
+		return err
+	}
+	absmachinebasedir, err := filepath.Abs(machinebasedir)
+	if err != nil {
+		return err
+	}
+
+	machines := make([]*Machine, len(systems))
+	params := []string{"import", ovafile}
+	for index, system := range systems {
+		machinename := system.ID
+		if name := metadata[index][ovfKeyMachine]; name != "" {
+			machinename = name
+		}
+
+		machines[index] = &Machine{
+			driver:      vd,
+			name:        machinename,
+			clustername: clustername,
+		}
+		machines[index].info = importedmachineinfo(system.Product, clustername)
+
+		vsys := strconv.Itoa(index)
+		params = append(
+			params,
+			"--vsys", vsys, "--vmname", machines[index].qname(),
+			"--vsys", vsys, "--group", "/"+clustername,
+			"--vsys", vsys, "--basefolder", absmachinebasedir,
+		)
+	}
+
+	kuttilog.Printf(kuttilog.Info, "Importing %d machine(s) from %s...", len(systems), ovafile)
+	output, err := workspace.RunWithResults(
+		vd.vboxmanagepath,
+		params...,
+	)
+	if err != nil {
+		return fmt.Errorf("could not import %s: %v:%s", ovafile, err, output)
+	}
+
+	networkname := vd.QualifiedNetworkName(clustername)
+	for index, machine := range machines {
+		err = vd.restoreimportedmachine(machine, networkname, metadata[index])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// importedmachineinfo returns the creation details of a machine, as
+// recorded in the product section of an exported OVA file. The machine
+// belongs to the cluster it is imported into.
+func importedmachineinfo(product ovfproductsection, clustername string) machineinfo {
+	properties := product.properties()
+
+	result := machineinfo{
+		imagechecksum: properties[ovfKeyImageChecksum],
+		driverversion: properties[ovfKeyDriverVersion],
+		clustername:   clustername,
+		role:          properties[ovfKeyRole],
+	}
+	if product.Product == ovfProduct {
+		result.k8sversion = product.Version
+	}
+	result.creationtime, _ = time.Parse(time.RFC3339, properties[ovfKeyCreationTime])
+
+	return result
+}
+
+func (vd *Driver) restoreimportedmachine(machine *Machine, networkname string, metadata map[string]string) error {
+	kuttilog.Printf(kuttilog.Info, "Attaching host %s to network...", machine.name)
+	output, err := workspace.RunWithResults(
+		vd.vboxmanagepath,
+		"modifyvm",
+		machine.qname(),
+		"--nic1",
+		"natnetwork",
+		"--nat-network1",
+		networkname,
+	)
+	if err != nil {
+		return fmt.Errorf("could not attach node %s to network %s: %v:%s", machine.name, networkname, err, output)
+	}
+
+	// Creation details, including the image version used to track
+	// which images are in use
+	err = machine.recordinfo()
+	if err != nil {
+		return err
+	}
+
+	if ipaddress := metadata[ovfKeySavedIP]; ipaddress != "" {
+		err = machine.setproperty(propSavedIPAddress, ipaddress)
+		if err != nil {
+			return err
+		}
+		machine.savedipaddress = ipaddress
+
+		output, err = workspace.RunWithResults(
+			vd.vboxmanagepath,
+			"dhcpserver",
+			"modify",
+			"--netname",
+			networkname,
+			"--vm",
+			machine.qname(),
+			"--nic",
+			"1",
+			"--fixed-address",
+			ipaddress,
+		)
+		if err != nil {
+			return fmt.Errorf("could not reserve IP address %s for node %s: %v:%s", ipaddress, machine.name, err, output)
+		}
+	}
+
+	for _, forward := range strings.Split(metadata[ovfKeyForwards], ",") {
+		if forward == "" {
+			continue
+		}
+
+		var hostport, machineport int
+		_, err = fmt.Sscanf(forward, "%d:%d", &hostport, &machineport)
+		if err != nil {
+			kuttilog.Printf(kuttilog.Info, "Ignoring invalid port forwarding rule '%s' for node %s.", forward, machine.name)
+			continue
+		}
+
+		if machineport == 22 {
+			err = machine.ForwardSSHPort(hostport)
+		} else {
+			err = machine.ForwardPort(hostport, machineport)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,169 @@
+package drivervbox
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInsertOVFProperties(t *testing.T) {
+	properties := [][]ovfproperty{
+		{
+			{Key: ovfKeyCluster, Value: "zang"},
+			{Key: ovfKeyMachine, Value: "node1"},
+			{Key: ovfKeyForwards, Value: "10001:22,10080:80"},
+			{Key: ovfKeyRole, Value: `a "quoted" <role>`},
+		},
+	}
+
+	descriptor, err := insertovfproperties([]byte(testovf), properties)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	envelope := &ovfenvelope{}
+	err = xml.Unmarshal(descriptor, envelope)
+	if err != nil {
+		t.Fatalf("descriptor with properties does not parse: %v", err)
+	}
+
+	product := envelope.virtualsystems()[0].Product
+	result := product.properties()
+	for _, property := range properties[0] {
+		if result[property.Key] != property.Value {
+			t.Errorf("property %s = %q; want %q", property.Key, result[property.Key], property.Value)
+		}
+	}
+	if product.Version != "1.31.2" {
+		t.Errorf("product version lost: %q", product.Version)
+	}
+
+	_, err = insertovfproperties([]byte(testovf), append(properties, properties[0]))
+	if err == nil {
+		t.Errorf("properties for more systems than product sections accepted")
+	}
+}
+
+func TestUpdateOVFManifest(t *testing.T) {
+	manifest := "SHA256 (kutti.ovf) = 00\nSHA256 (kutti-disk001.vmdk) = 11\n"
+	data := []byte("new descriptor")
+	sum := sha256.Sum256(data)
+
+	expected := "SHA256 (kutti.ovf) = " + hex.EncodeToString(sum[:]) + "\nSHA256 (kutti-disk001.vmdk) = 11\n"
+	if result := string(updateovfmanifest([]byte(manifest), "kutti.ovf", data)); result != expected {
+		t.Errorf("unexpected manifest:\n got %q\nwant %q", result, expected)
+	}
+}
+
+func TestRewriteOVFArchive(t *testing.T) {
+	files := []struct {
+		name string
+		data string
+	}{
+		{"kutti.ovf", "descriptor"},
+		{"kutti.mf", "SHA256 (kutti.ovf) = 00\n"},
+		{"kutti-disk001.vmdk", "disk"},
+	}
+
+	source := &bytes.Buffer{}
+	tw := tar.NewWriter(source)
+	for _, file := range files {
+		tw.WriteHeader(&tar.Header{Name: file.name, Mode: 0644, Size: int64(len(file.data))})
+		tw.Write([]byte(file.data))
+	}
+	tw.Close()
+
+	dest := &bytes.Buffer{}
+	err := rewriteovfarchive(source, dest, func(descriptor []byte) ([]byte, error) {
+		return append(descriptor, " rewritten"...), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum := sha256.Sum256([]byte("descriptor rewritten"))
+	expected := map[string]string{
+		"kutti.ovf":          "descriptor rewritten",
+		"kutti.mf":           "SHA256 (kutti.ovf) = " + hex.EncodeToString(sum[:]) + "\n",
+		"kutti-disk001.vmdk": "disk",
+	}
+
+	tr := tar.NewReader(dest)
+	count := 0
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, _ := io.ReadAll(tr)
+		if string(data) != expected[header.Name] {
+			t.Errorf("file %s = %q; want %q", header.Name, data, expected[header.Name])
+		}
+		count++
+	}
+	if count != len(files) {
+		t.Errorf("rewritten archive has %d files; want %d", count, len(files))
+	}
+}
+
+func TestImportedMachineInfo(t *testing.T) {
+	product := ovfproductsection{
+		Product: ovfProduct,
+		Version: "1.31.2",
+		Properties: []ovfproperty{
+			{Key: ovfKeyCluster, Value: "oldcluster"},
+			{Key: ovfKeyImageChecksum, Value: "abc"},
+			{Key: ovfKeyRole, Value: "control-plane"},
+			{Key: ovfKeyCreationTime, Value: "2024-05-01T10:00:00Z"},
+		},
+	}
+
+	info := importedmachineinfo(product, "newcluster")
+	if info.k8sversion != "1.31.2" || info.imagechecksum != "abc" || info.role != "control-plane" {
+		t.Errorf("unexpected info: %+v", info)
+	}
+	if info.clustername != "newcluster" {
+		t.Errorf("cluster name %q; want the cluster imported into", info.clustername)
+	}
+	if !info.creationtime.Equal(time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("creation time %v not restored", info.creationtime)
+	}
+
+	product.Product = "other"
+	if info := importedmachineinfo(product, "newcluster"); info.k8sversion != "" {
+		t.Errorf("version of non-kutti product used: %q", info.k8sversion)
+	}
+}
+
+func TestMachineOVFProperties(t *testing.T) {
+	machine := &Machine{driver: &Driver{}, name: "node1", clustername: "zang", savedipaddress: "192.168.125.10"}
+	rules := []PortForwardRule{
+		{Name: machine.forwardingrulename(22), HostPort: 10001, GuestPort: 22},
+		{Name: "someone else's rule", HostPort: 10002, GuestPort: 22},
+	}
+
+	result := map[string]string{}
+	for _, property := range machineovfproperties(machine, rules) {
+		result[property.Key] = property.Value
+	}
+
+	if result[ovfKeyForwards] != "10001:22" {
+		t.Errorf("forwards = %q; want %q", result[ovfKeyForwards], "10001:22")
+	}
+	if result[ovfKeyCluster] != "zang" || result[ovfKeyMachine] != "node1" || result[ovfKeySavedIP] != "192.168.125.10" {
+		t.Errorf("unexpected properties: %v", result)
+	}
+	if _, ok := result[ovfKeyRole]; ok || strings.Contains(result[ovfKeyCreationTime], "0001") {
+		t.Errorf("unknown details exported: %v", result)
+	}
+}
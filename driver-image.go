@@ -2,71 +2,203 @@ package drivervbox
 
 import (
 	"fmt"
+	"path/filepath"
 
 	"github.com/kuttiproject/drivercore"
+	"github.com/kuttiproject/kuttilog"
 )
 
+// ImageListChanges reports the differences found when the image list
+// is updated. Each list contains Kubernetes versions.
+type ImageListChanges struct {
+	// Added images are new in the list.
+	Added []string
+	// Removed images are no longer in the list, and were not downloaded.
+	Removed []string
+	// Retired images are no longer in the list, but were downloaded. They
+	// are kept as retired entries, so that their cached files are known.
+	Retired []string
+	// Deprecated images have been newly deprecated.
+	Deprecated []string
+	// Changed images have a new source URL or checksum. If the checksum
+	// changed, a downloaded image must be downloaded again.
+	Changed []string
+	// FilesRemoved contains the names of unreferenced image files removed
+	// from the cache, if that was requested.
+	FilesRemoved []string
+}
+
 // UpdateImageList fetches the latest list of VM images from the driver source URL.
+// See UpdateImageListWithReport for how local state is preserved.
 func (vd *Driver) UpdateImageList() error {
-	return fetchimagelist()
+	_, err := fetchimagelist(false)
+	return err
+}
+
+// UpdateImageListWithReport fetches the latest list of VM images from the driver
+// source URL, and reports what changed.
+// Downloaded images keep their status if their checksum is unchanged. Downloaded
+// images that are no longer in the list are kept as retired entries. Locally
+// registered images are always kept. If an image source cannot be fetched
+// or verified, the images from it are kept unchanged.
+// If removeunreferenced is true, image files in the local cache that no
+// downloaded image refers to, including files whose image checksum has
+// changed, are removed.
+func (vd *Driver) UpdateImageListWithReport(removeunreferenced bool) (*ImageListChanges, error) {
+	return fetchimagelist(removeunreferenced)
 }
 
 // ValidK8sVersion returns true if the specified Kubernetes version is available.
+// The version can also be an alias, as described in ResolveK8sVersion.
 func (vd *Driver) ValidK8sVersion(k8sversion string) bool {
 	err := imageconfigmanager.Load()
 	if err != nil {
 		return false
 	}
 
-	_, ok := imagedata.images[k8sversion]
+	_, ok := resolvek8sversion(imagedata.images, k8sversion)
 	return ok
 }
 
-// K8sVersions returns all Kubernetes versions currently supported by kutti.
+// ResolveK8sVersion resolves a Kubernetes version or alias to an available
+// version. An exact match always wins. Otherwise, "latest" resolves to the
+// newest version, "stable" to the newest version that is not deprecated,
+// and "major.minor" (for example "1.31") to the newest "major.minor.patch"
+// version. Locally registered and retired images are only matched exactly.
+func (vd *Driver) ResolveK8sVersion(k8sversion string) (string, error) {
+	err := imageconfigmanager.Load()
+	if err != nil {
+		return "", err
+	}
+
+	result, ok := resolvek8sversion(imagedata.images, k8sversion)
+	if !ok {
+		return "", fmt.Errorf("no image present for K8s version %s", k8sversion)
+	}
+
+	return result, nil
+}
+
+// K8sVersions returns all Kubernetes versions currently supported by kutti,
+// sorted by semantic version.
 func (vd *Driver) K8sVersions() []string {
 	err := imageconfigmanager.Load()
 	if err != nil {
 		return []string{}
 	}
 
-	result := make([]string, len(imagedata.images))
-	index := 0
-	for _, value := range imagedata.images {
-		result[index] = value.imageK8sVersion
-		index++
+	result := make([]string, 0, len(imagedata.images))
+	for _, key := range sortedimagekeys(imagedata.images) {
+		result = append(result, imagedata.images[key].imageK8sVersion)
 	}
 
 	return result
 }
 
-// ListImages lists the currently available Images.
+// ListImages lists the currently available Images, sorted by
+// semantic version.
 func (vd *Driver) ListImages() ([]drivercore.Image, error) {
 	err := imageconfigmanager.Load()
 	if err != nil {
 		return []drivercore.Image{}, err
 	}
 
-	result := make([]drivercore.Image, len(imagedata.images))
-	index := 0
-	for _, value := range imagedata.images {
-		result[index] = value
-		index++
+	result := make([]drivercore.Image, 0, len(imagedata.images))
+	for _, key := range sortedimagekeys(imagedata.images) {
+		result = append(result, imagedata.images[key])
 	}
 
 	return result, nil
 }
 
 // GetImage returns an image corresponding to a Kubernetes version, or an error.
+// The version can also be an alias, as described in ResolveK8sVersion.
 func (vd *Driver) GetImage(k8sversion string) (drivercore.Image, error) {
 	err := imageconfigmanager.Load()
 	if err != nil {
 		return nil, err
 	}
 
-	img, ok := imagedata.images[k8sversion]
+	key, ok := resolvek8sversion(imagedata.images, k8sversion)
 	if !ok {
-		return img, fmt.Errorf("no image present for K8s version %s", k8sversion)
+		return nil, fmt.Errorf("no image present for K8s version %s", k8sversion)
+	}
+
+	return imagedata.images[key], nil
+}
+
+// RegisterLocalImage registers a locally built OVA file as an image, under
+// the specified tag. The tag is used in place of a Kubernetes version, for
+// example when creating Machines. The file is copied into the local cache,
+// and its checksum is computed on the way.
+// A tag cannot be the same as a version obtained from an image source. If
+// a local image with the same tag already exists, it is replaced, unless
+// Machines were created from it. In that case, an error listing them is
+// returned. Use ForceRegisterLocalImage to replace it anyway.
+// Locally registered images are kept when the image list is updated.
+// A file built for a processor architecture other than the host's is
+// rejected.
+func (vd *Driver) RegisterLocalImage(tag string, ovafile string) (drivercore.Image, error) {
+	return vd.registerlocalimage(tag, ovafile, false)
+}
+
+// ForceRegisterLocalImage registers a locally built OVA file as an image in
+// the same way as RegisterLocalImage, but replaces an existing local image
+// with the same tag even if Machines were created from it. A warning is
+// logged for each such Machine.
+func (vd *Driver) ForceRegisterLocalImage(tag string, ovafile string) (drivercore.Image, error) {
+	return vd.registerlocalimage(tag, ovafile, true)
+}
+
+func (vd *Driver) registerlocalimage(tag string, ovafile string, force bool) (drivercore.Image, error) {
+	err := imageconfigmanager.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	err = checklocalimagetag(tag, imagedata.images)
+	if err != nil {
+		return nil, err
+	}
+
+	// Machines may have been created from an existing local image
+	if _, ok := imagedata.images[tag]; ok {
+		imagemachines, err := machinesbyimage()
+		if err != nil {
+			return nil, err
+		}
+
+		if force {
+			for _, machinename := range imagemachines[tag] {
+				kuttilog.Printf(kuttilog.Minimal, "Warning: machine %s was created from the image being replaced for K8s version %s.", machinename, tag)
+			}
+		} else {
+			err = checkimagenotinuse(tag, imagemachines)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	absfilepath, err := filepath.Abs(ovafile)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkimagearchitecture(absfilepath)
+	if err != nil {
+		return nil, err
+	}
+
+	img, err := addlocalimage(tag, absfilepath)
+	if err != nil {
+		return nil, err
+	}
+
+	if imagedata.images == nil {
+		imagedata.images = map[string]*Image{}
 	}
+	imagedata.images[tag] = img
 
-	return img, nil
+	return img, imageconfigmanager.Save()
 }
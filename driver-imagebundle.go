@@ -0,0 +1,336 @@
+package drivervbox
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+
+	"github.com/kuttiproject/drivercore"
+	"github.com/kuttiproject/kuttilog"
+)
+
+// ExportImageBundle writes the specified images, with their image list
+// entries, into a single tar file that can be imported on another computer
+// with ImportImageBundle, without network access. If no Kubernetes versions
+// are specified, all downloaded images are exported.
+// The bundle contains a driver-vbox-images.json file with the entries,
+// followed by the OVA file of each image.
+func (vd *Driver) ExportImageBundle(bundlefile string, k8sversions ...string) error {
+	err := imageconfigmanager.Load()
+	if err != nil {
+		return err
+	}
+
+	if len(k8sversions) == 0 {
+		for key, image := range imagedata.images {
+			if image.imageStatus == drivercore.ImageStatusDownloaded {
+				k8sversions = append(k8sversions, key)
+			}
+		}
+		sort.Strings(k8sversions)
+	}
+
+	if len(k8sversions) == 0 {
+		return errors.New("no downloaded images to export")
+	}
+
+	entries := map[string]*Image{}
+	for _, k8sversion := range k8sversions {
+		image, ok := imagedata.images[k8sversion]
+		if !ok {
+			return fmt.Errorf("no image present for K8s version %s", k8sversion)
+		}
+		if image.imageStatus != drivercore.ImageStatusDownloaded {
+			return fmt.Errorf("image for K8s version %s is not downloaded", k8sversion)
+		}
+		entries[k8sversion] = image
+	}
+
+	listdata, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(bundlefile)
+	if err != nil {
+		return err
+	}
+
+	err = writeimagebundle(f, listdata, k8sversions)
+	closeerr := f.Close()
+	if err != nil {
+		os.Remove(bundlefile)
+		return err
+	}
+
+	return closeerr
+}
+
+func writeimagebundle(w io.Writer, listdata []byte, k8sversions []string) error {
+	tw := tar.NewWriter(w)
+
+	err := tw.WriteHeader(&tar.Header{
+		Name: imagesConfigFile,
+		Mode: 0644,
+		Size: int64(len(listdata)),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = tw.Write(listdata)
+	if err != nil {
+		return err
+	}
+
+	for _, k8sversion := range k8sversions {
+		kuttilog.Printf(kuttilog.Info, "Adding image for K8s version %s...", k8sversion)
+		err = addbundlefile(tw, k8sversion)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tw.Close()
+}
+
+func addbundlefile(tw *tar.Writer, k8sversion string) error {
+	imagepath, err := imagepathfromk8sversion(k8sversion)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(imagepath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	err = tw.WriteHeader(&tar.Header{
+		Name: imagenamefromk8sversion(k8sversion),
+		Mode: 0644,
+		Size: info.Size(),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// ImportImageBundle imports images from a bundle created by
+// ExportImageBundle. Each image is verified against the checksum in the
+// bundle's image list, copied into the local cache, and registered as
+// downloaded. Entries for the same Kubernetes versions in the current
+// image list are replaced.
+// All images in the bundle are verified before any of them are moved
+// into the cache, so a bundle is either imported completely or not at all.
+// A cached image that Machines were created from is not replaced, unless
+// the bundle contains an identical image.
+func (vd *Driver) ImportImageBundle(bundlefile string) error {
+	err := imageconfigmanager.Load()
+	if err != nil {
+		return err
+	}
+
+	cachedir, err := vboxCacheDir()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(bundlefile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	imagemachines, err := machinesbyimage()
+	if err != nil {
+		return err
+	}
+
+	// A cached image that machines were created from can only be replaced
+	// by an identical one
+	canreplace := func(k8sversion string, checksum string) error {
+		existing, ok := imagedata.images[k8sversion]
+		if !ok || existing.imageStatus != drivercore.ImageStatusDownloaded || existing.imageChecksum == checksum {
+			return nil
+		}
+		return checkimagenotinuse(k8sversion, imagemachines)
+	}
+
+	images, err := importimagebundle(f, cachedir, canreplace)
+	if err != nil {
+		return fmt.Errorf("could not import bundle %s: %v", bundlefile, err)
+	}
+
+	if imagedata.images == nil {
+		imagedata.images = map[string]*Image{}
+	}
+	for k8sversion, image := range images {
+		imagedata.images[k8sversion] = image
+	}
+
+	return imageconfigmanager.Save()
+}
+
+// importimagebundle reads a bundle, verifying each image into a temporary
+// file in the cache directory. Only if all images are valid, and canreplace
+// allows each of them to replace any existing cached image, are they moved
+// into place. If moving any image fails, the images already moved are
+// removed, and the cached images they replaced are restored. It returns the
+// imported images, marked as downloaded.
+func importimagebundle(r io.Reader, cachedir string, canreplace func(k8sversion string, checksum string) error) (map[string]*Image, error) {
+	var entries map[string]*Image
+	staged := map[string]string{}
+	defer func() {
+		// Anything left here was not moved into place
+		for _, importpath := range staged {
+			discarddownload(importpath)
+		}
+	}()
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if header.Name == imagesConfigFile {
+			entries = map[string]*Image{}
+			err = json.NewDecoder(tr).Decode(&entries)
+			if err != nil {
+				return nil, fmt.Errorf("invalid image list: %v", err)
+			}
+
+			// Keys become file names in the cache directory
+			for k8sversion, image := range entries {
+				if !imagetagpattern.MatchString(k8sversion) || image == nil {
+					return nil, fmt.Errorf("invalid image list: invalid entry for K8s version '%s'", k8sversion)
+				}
+			}
+			continue
+		}
+
+		if entries == nil {
+			return nil, errors.New("bundle does not start with an image list")
+		}
+
+		key := ""
+		for k8sversion := range entries {
+			if header.Name == imagenamefromk8sversion(k8sversion) {
+				key = k8sversion
+				break
+			}
+		}
+		if key == "" {
+			kuttilog.Printf(kuttilog.Info, "Skipping unknown file %s in bundle.", header.Name)
+			continue
+		}
+		image := entries[key]
+
+		kuttilog.Printf(kuttilog.Info, "Verifying image for K8s version %s...", key)
+		importpath := path.Join(cachedir, fmt.Sprintf("kutti-k8s-%s.ovaimport", key))
+		staged[key] = importpath
+
+		hasher := newchecksumhasher()
+		err = copybundlefile(tr, importpath, hasher)
+		if err != nil {
+			return nil, err
+		}
+
+		filechecksum := checksumstring(hasher)
+		if filechecksum != image.imageChecksum {
+			kuttilog.Printf(kuttilog.Debug, "checksum for image %v failed.\nWanted: %v\nGot   : %v\n", key, image.imageChecksum, filechecksum)
+			return nil, fmt.Errorf("image for K8s version %s is not valid", key)
+		}
+
+		err = canreplace(key, filechecksum)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(staged) == 0 {
+		return nil, errors.New("no images found")
+	}
+
+	// Existing cached images are set aside until all images are in place,
+	// so that they can be restored if moving any image fails.
+	moved := []string{}
+	backups := map[string]string{}
+	rollback := func() {
+		for _, imagepath := range moved {
+			os.Remove(imagepath)
+		}
+		for imagepath, backuppath := range backups {
+			os.Rename(backuppath, imagepath)
+		}
+	}
+
+	for k8sversion, importpath := range staged {
+		imagepath := path.Join(cachedir, imagenamefromk8sversion(k8sversion))
+
+		if _, err := os.Stat(imagepath); err == nil {
+			backuppath := imagepath + "backup"
+			err = os.Rename(imagepath, backuppath)
+			if err != nil {
+				rollback()
+				return nil, err
+			}
+			backups[imagepath] = backuppath
+		}
+
+		err := os.Rename(importpath, imagepath)
+		if err != nil {
+			rollback()
+			return nil, err
+		}
+		moved = append(moved, imagepath)
+	}
+
+	for _, backuppath := range backups {
+		os.Remove(backuppath)
+	}
+
+	result := map[string]*Image{}
+	for k8sversion, importpath := range staged {
+		discarddownload(importpath)
+
+		image := entries[k8sversion]
+		image.imageStatus = drivercore.ImageStatusDownloaded
+		result[k8sversion] = image
+	}
+	staged = map[string]string{}
+
+	return result, nil
+}
+
+func copybundlefile(r io.Reader, destpath string, hasher io.Writer) error {
+	dest, err := os.Create(destpath)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(io.MultiWriter(dest, hasher), r)
+	closeerr := dest.Close()
+	if err != nil {
+		return err
+	}
+
+	return closeerr
+}
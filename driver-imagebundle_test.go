@@ -0,0 +1,219 @@
+package drivervbox
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/kuttiproject/drivercore"
+)
+
+func testimagebundle(t *testing.T, files map[string]string, checksums map[string]string) *bytes.Buffer {
+	t.Helper()
+
+	entries := map[string]*Image{}
+	for k8sversion, checksum := range checksums {
+		entries[k8sversion] = &Image{imageK8sVersion: k8sversion, imageChecksum: checksum}
+	}
+	listdata, err := json.Marshal(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := &bytes.Buffer{}
+	tw := tar.NewWriter(result)
+	add := func(name string, data []byte) {
+		err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data))})
+		if err == nil {
+			_, err = tw.Write(data)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	add(imagesConfigFile, listdata)
+	for _, k8sversion := range sortedkeys(files) {
+		add(imagenamefromk8sversion(k8sversion), []byte(files[k8sversion]))
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return result
+}
+
+func sortedkeys(m map[string]string) []string {
+	result := make([]string, 0, len(m))
+	for key := range m {
+		result = append(result, key)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func testchecksum(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func replaceany(k8sversion string, checksum string) error {
+	return nil
+}
+
+func TestImportImageBundle(t *testing.T) {
+	files := map[string]string{"1.31": "first image", "1.32": "second image"}
+	checksums := map[string]string{"1.31": testchecksum("first image"), "1.32": testchecksum("second image")}
+
+	cachedir := t.TempDir()
+	images, err := importimagebundle(testimagebundle(t, files, checksums), cachedir, replaceany)
+	if err != nil {
+		t.Fatalf("valid bundle rejected: %v", err)
+	}
+
+	for k8sversion, content := range files {
+		image, ok := images[k8sversion]
+		if !ok || image.Status() != drivercore.ImageStatusDownloaded {
+			t.Errorf("image %s not imported as downloaded", k8sversion)
+		}
+
+		data, err := os.ReadFile(filepath.Join(cachedir, imagenamefromk8sversion(k8sversion)))
+		if err != nil || string(data) != content {
+			t.Errorf("image file %s not imported correctly: %v", k8sversion, err)
+		}
+	}
+}
+
+func TestImportImageBundleCorrupt(t *testing.T) {
+	files := map[string]string{"1.31": "first image", "1.32": "corrupted image"}
+	checksums := map[string]string{"1.31": testchecksum("first image"), "1.32": testchecksum("second image")}
+
+	cachedir := t.TempDir()
+	images, err := importimagebundle(testimagebundle(t, files, checksums), cachedir, replaceany)
+	if err == nil {
+		t.Fatalf("bundle with corrupt image accepted")
+	}
+	if len(images) != 0 {
+		t.Errorf("images imported from corrupt bundle: %v", images)
+	}
+
+	entries, err := os.ReadDir(cachedir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		t.Errorf("file %s left in cache after failed import", entry.Name())
+	}
+}
+
+func TestImportImageBundleNoList(t *testing.T) {
+	bundle := &bytes.Buffer{}
+	tw := tar.NewWriter(bundle)
+	tw.WriteHeader(&tar.Header{Name: imagenamefromk8sversion("1.31"), Mode: 0644, Size: 1})
+	tw.Write([]byte("x"))
+	tw.Close()
+
+	if _, err := importimagebundle(bundle, t.TempDir(), replaceany); err == nil {
+		t.Errorf("bundle without image list accepted")
+	}
+}
+
+func TestImportImageBundleInUse(t *testing.T) {
+	files := map[string]string{"1.31": "first image", "1.32": "second image"}
+	checksums := map[string]string{"1.31": testchecksum("first image"), "1.32": testchecksum("second image")}
+
+	inuse := func(k8sversion string, checksum string) error {
+		return checkimagenotinuse(k8sversion, map[string][]string{"1.32": {"test-node1"}})
+	}
+
+	cachedir := t.TempDir()
+	_, err := importimagebundle(testimagebundle(t, files, checksums), cachedir, inuse)
+	if err == nil {
+		t.Fatalf("bundle replacing an image in use accepted")
+	}
+
+	entries, _ := os.ReadDir(cachedir)
+	for _, entry := range entries {
+		t.Errorf("file %s left in cache after refused import", entry.Name())
+	}
+}
+
+func TestImportImageBundleInvalidKey(t *testing.T) {
+	root := t.TempDir()
+	cachedir := filepath.Join(root, "config", "cache")
+	if err := os.MkdirAll(cachedir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"x/../../../escaped", "../escaped", "x/y", ""} {
+		files := map[string]string{key: "rogue image"}
+		checksums := map[string]string{key: testchecksum("rogue image")}
+
+		_, err := importimagebundle(testimagebundle(t, files, checksums), cachedir, replaceany)
+		if err == nil {
+			t.Errorf("bundle with image key %q accepted", key)
+		}
+	}
+
+	err := filepath.WalkDir(root, func(path string, entry os.DirEntry, err error) error {
+		if err == nil && !entry.IsDir() {
+			t.Errorf("file %s written by rejected bundle", path)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestImportImageBundleRollback(t *testing.T) {
+	files := map[string]string{"1.31": "first image", "1.32": "second image"}
+	checksums := map[string]string{"1.31": testchecksum("first image"), "1.32": testchecksum("second image")}
+
+	cachedir := t.TempDir()
+	existing := map[string]string{"1.31": "old first image", "1.32": "old second image"}
+	for k8sversion, content := range existing {
+		err := os.WriteFile(filepath.Join(cachedir, imagenamefromk8sversion(k8sversion)), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Setting aside the existing image for 1.32 fails
+	blocker := filepath.Join(cachedir, imagenamefromk8sversion("1.32")+"backup")
+	if err := os.MkdirAll(filepath.Join(blocker, "blocker"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	images, err := importimagebundle(testimagebundle(t, files, checksums), cachedir, replaceany)
+	if err == nil {
+		t.Fatalf("import succeeded although an image could not be moved into place")
+	}
+	if len(images) != 0 {
+		t.Errorf("images reported as imported after failed import: %v", images)
+	}
+
+	for k8sversion, content := range existing {
+		data, err := os.ReadFile(filepath.Join(cachedir, imagenamefromk8sversion(k8sversion)))
+		if err != nil || string(data) != content {
+			t.Errorf("cached image %s not restored: %q, %v", k8sversion, data, err)
+		}
+	}
+
+	if err := os.RemoveAll(blocker); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := os.ReadDir(cachedir)
+	if len(entries) != len(existing) {
+		for _, entry := range entries {
+			t.Logf("file %s in cache", entry.Name())
+		}
+		t.Errorf("leftover files in cache after failed import")
+	}
+}
@@ -0,0 +1,130 @@
+package drivervbox
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/kuttiproject/kuttilog"
+)
+
+// ImageUsage describes which Machines were created from an image, and
+// how much disk space its cached copy takes.
+type ImageUsage struct {
+	K8sVersion string
+	// Machines contains the VirtualBox names of Machines created from
+	// the image, in the format <clustername>-<machinename>.
+	Machines []string
+	// FileSizeMB is the size of the image's cached copy. It is zero if
+	// the image has not been downloaded.
+	FileSizeMB int64
+}
+
+// ImageUsage reports, for every image, the Machines created from it and
+// the disk space taken by its cached copy. Images are reported in the same
+// order as ListImages.
+// Machines are matched to images by the Kubernetes version recorded when
+// they were created. Machines created by earlier versions of the driver do
+// not record it, and are not reported.
+// It does this by running the command:
+//   VBoxManage list vms
+// and then, for each VM:
+//   VBoxManage guestproperty get <machinename> /kutti/VMInfo/K8sVersion
+func (vd *Driver) ImageUsage() ([]ImageUsage, error) {
+	if !vd.validate() {
+		return nil, vd
+	}
+
+	err := imageconfigmanager.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	imagemachines, err := vd.imagemachines()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]ImageUsage, 0, len(imagedata.images))
+	for _, key := range sortedimagekeys(imagedata.images) {
+		usage := ImageUsage{
+			K8sVersion: key,
+			Machines:   imagemachines[key],
+		}
+
+		imagepath, err := imagepathfromk8sversion(key)
+		if err != nil {
+			return nil, err
+		}
+		if fileinfo, err := os.Stat(imagepath); err == nil {
+			usage.FileSizeMB = fileinfo.Size() / (1 << 20)
+		}
+
+		result = append(result, usage)
+	}
+
+	return result, nil
+}
+
+// imagemachines returns the names of all VMs created from each image,
+// keyed by the Kubernetes version of the image.
+func (vd *Driver) imagemachines() (map[string][]string, error) {
+	machinenames, err := vd.machinenames()
+	if err != nil {
+		return nil, err
+	}
+
+	return groupimagemachines(machinenames, func(machinename string) (string, bool) {
+		return vd.vmproperty(machinename, propK8sVersion)
+	}), nil
+}
+
+// groupimagemachines groups machine names by the Kubernetes version of
+// the image they were created from, as returned by k8sversionof. Machines
+// without a recorded version are left out.
+func groupimagemachines(machinenames []string, k8sversionof func(string) (string, bool)) map[string][]string {
+	result := map[string][]string{}
+	for _, machinename := range machinenames {
+		k8sversion, ok := k8sversionof(machinename)
+		if !ok {
+			continue
+		}
+
+		k8sversion = trimpropend(k8sversion)
+		if k8sversion == "" {
+			continue
+		}
+		result[k8sversion] = append(result[k8sversion], machinename)
+	}
+
+	return result
+}
+
+// machinesbyimage returns the names of all VMs created from each image,
+// keyed by the Kubernetes version of the image. It is used to guard cached
+// image files against removal or replacement. If VBoxManage is not
+// available, no VMs can exist, so an empty result is returned.
+func machinesbyimage() (map[string][]string, error) {
+	vd := &Driver{}
+	if !vd.validate() {
+		kuttilog.Printf(kuttilog.Info, "Could not check which machines use images: %v. Assuming none.", vd.Error())
+		return map[string][]string{}, nil
+	}
+
+	return vd.imagemachines()
+}
+
+// checkimagenotinuse returns an error if any machines were created from
+// the image for the specified Kubernetes version.
+func checkimagenotinuse(k8sversion string, imagemachines map[string][]string) error {
+	machinenames := imagemachines[k8sversion]
+	if len(machinenames) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf(
+		"image for K8s version %s is in use by machines: %s",
+		k8sversion,
+		strings.Join(machinenames, ", "),
+	)
+}
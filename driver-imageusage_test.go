@@ -0,0 +1,45 @@
+package drivervbox
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGroupImageMachines(t *testing.T) {
+	properties := map[string]string{
+		"zang-node1": "1.31.2\n",
+		"zang-node2": "1.31.2\n",
+		"zing-node1": "mytag\n",
+		"zing-node2": "",
+	}
+	k8sversionof := func(machinename string) (string, bool) {
+		value, ok := properties[machinename]
+		return value, ok
+	}
+
+	machinenames := []string{"zang-node1", "zing-node1", "zang-node2", "zing-node2", "other"}
+	expected := map[string][]string{
+		"1.31.2": {"zang-node1", "zang-node2"},
+		"mytag":  {"zing-node1"},
+	}
+
+	if result := groupimagemachines(machinenames, k8sversionof); !reflect.DeepEqual(result, expected) {
+		t.Errorf("unexpected grouping:\n got %v\nwant %v", result, expected)
+	}
+}
+
+func TestCheckImageNotInUse(t *testing.T) {
+	imagemachines := map[string][]string{
+		"1.31.2": {"zang-node1", "zang-node2"},
+	}
+
+	if err := checkimagenotinuse("1.31.2", imagemachines); err == nil {
+		t.Errorf("image in use not reported")
+	}
+	if err := checkimagenotinuse("1.32.0", imagemachines); err != nil {
+		t.Errorf("unused image reported as in use: %v", err)
+	}
+	if err := checkimagenotinuse("1.32.0", nil); err != nil {
+		t.Errorf("unused image reported as in use with no machines: %v", err)
+	}
+}
@@ -2,10 +2,10 @@ package drivervbox
 
 import (
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
 	"regexp"
-	"strings"
 	"time"
 
 	"github.com/kuttiproject/drivercore"
@@ -72,6 +72,42 @@ func (vd *Driver) DeleteMachine(machinename string, clustername string) error {
 
 var ipRegex, _ = regexp.Compile(`^(([0-9]|[1-9][0-9]|1[0-9]{2}|2[0-4][0-9]|25[0-5])\.){3}([0-9]|[1-9][0-9]|1[0-9]{2}|2[0-4][0-9]|25[0-5])$`)
 
+// isnetipv6address returns true if the specified string is an IPv6 address
+// inside the specified network prefix.
+func isnetipv6address(ipaddr string, ipv6prefix string) bool {
+	ip := net.ParseIP(ipaddr)
+	if ip == nil || ip.To4() != nil {
+		return false
+	}
+
+	_, prefix, err := net.ParseCIDR(ipv6prefix)
+	if err != nil {
+		return false
+	}
+
+	return prefix.Contains(ip)
+}
+
+// fetchwithretries calls fetch up to three times, waiting longer after each
+// failure, until it returns a non-empty value. The description is used for
+// logging.
+func fetchwithretries(description string, fetch func() string) string {
+	for retries := 1; retries < 4; retries++ {
+		kuttilog.Printf(kuttilog.Info, "Fetching %s (attempt %v/3)...", description, retries)
+
+		result := fetch()
+		if result != "" {
+			kuttilog.Printf(kuttilog.Info, "Obtained %s '%v'", description, result)
+			return result
+		}
+
+		kuttilog.Printf(kuttilog.Info, "Failed. Waiting %v seconds before retry...", retries*10)
+		time.Sleep(time.Duration(retries*10) * time.Second)
+	}
+
+	return ""
+}
+
 // NewMachine creates a VM, and connects it to a previously created NAT network.
 // It also starts the VM, changes the hostname, saves the IP address, and stops
 // it again.
@@ -81,14 +117,45 @@ var ipRegex, _ = regexp.Compile(`^(([0-9]|[1-9][0-9]|1[0-9]{2}|2[0-4][0-9]|25[0-
 // The first imports from an .ova file (easiest way to get fully configured VM), while
 // setting the VM name. The second connects the first network interface card to
 // the NAT network.
+// If EnableSerialConsole is true, the first serial port is also connected to
+// a log file in the VM's folder. See Machine.ConsoleLog().
+// An image built for a processor architecture other than the host's is
+// rejected before importing. See Image.Details().
 // This function may return nil and an error, or a Machine and an error.
 // In the second case, if the caller does not actually want the machine, they should
 // call DeleteMachine afterwards.
 func (vd *Driver) NewMachine(machinename string, clustername string, k8sversion string) (drivercore.Machine, error) {
+	return vd.NewMachineWithOptions(machinename, clustername, k8sversion, nil)
+}
+
+// MachineOptions specify additional settings for new Machines.
+// A nil *MachineOptions is the same as the zero value.
+type MachineOptions struct {
+	// Role is the role of the node in the cluster, such as "control-plane"
+	// or "worker". It is recorded on the Machine, and can be retrieved
+	// with Machine.Role().
+	Role string
+}
+
+// NewMachineWithOptions creates a VM in the same way as NewMachine, applying
+// the specified options.
+// Details of the VM's creation, namely the Kubernetes version and checksum of
+// the source image, the driver version, the creation time, the cluster name and
+// the node role, are recorded as guest properties under /kutti/VMInfo/.
+func (vd *Driver) NewMachineWithOptions(machinename string, clustername string, k8sversion string, options *MachineOptions) (drivercore.Machine, error) {
 	if !vd.validate() {
 		return nil, vd
 	}
 
+	if options == nil {
+		options = &MachineOptions{}
+	}
+
+	// Aliases such as "latest" are accepted, as in ValidK8sVersion
+	if resolvedversion, err := vd.ResolveK8sVersion(k8sversion); err == nil {
+		k8sversion = resolvedversion
+	}
+
 	qualifiedmachinename := vd.QualifiedMachineName(machinename, clustername)
 
 	kuttilog.Println(kuttilog.Info, "Importing image...")
@@ -102,6 +169,12 @@ func (vd *Driver) NewMachine(machinename string, clustername string, k8sversion
 		return nil, fmt.Errorf("could not retrieve image %s: %v", ovafile, err)
 	}
 
+	// Importing an image for the wrong architecture takes a long time to fail
+	err = checkimagearchitecture(ovafile)
+	if err != nil {
+		return nil, err
+	}
+
 	machinebasedir, err := machinesBaseDir()
 	if err != nil {
 		return nil, err
@@ -145,6 +218,22 @@ func (vd *Driver) NewMachine(machinename string, clustername string, k8sversion
 	}
 	networkname := vd.QualifiedNetworkName(clustername)
 
+	// Record how and when the VM was created
+	newmachine.info = machineinfo{
+		k8sversion:    k8sversion,
+		imagechecksum: imagechecksumfromk8sversion(k8sversion),
+		driverversion: DriverVersion,
+		creationtime:  time.Now().UTC().Truncate(time.Second),
+		clustername:   clustername,
+		role:          options.Role,
+	}
+	err = newmachine.recordinfo()
+	if err != nil {
+		newmachine.status = drivercore.MachineStatusError
+		newmachine.errormessage = err.Error()
+		return newmachine, err
+	}
+
 	_, err = workspace.RunWithResults(
 		vd.vboxmanagepath,
 		"modifyvm",
@@ -161,6 +250,17 @@ func (vd *Driver) NewMachine(machinename string, clustername string, k8sversion
 		return newmachine, fmt.Errorf("could not attach node %s to network %s: %v", machinename, networkname, err)
 	}
 
+	// Capture the serial console, if required
+	if EnableSerialConsole {
+		kuttilog.Println(kuttilog.Info, "Configuring serial console...")
+		err = newmachine.enableserialconsole()
+		if err != nil {
+			newmachine.status = drivercore.MachineStatusError
+			newmachine.errormessage = err.Error()
+			return newmachine, err
+		}
+	}
+
 	// Start the host
 	kuttilog.Println(kuttilog.Info, "Starting host...")
 	err = newmachine.Start()
@@ -192,43 +292,26 @@ func (vd *Driver) NewMachine(machinename string, clustername string, k8sversion
 	// In some cases, VirtualBox picks up other interfaces first. So, we check
 	// up to three interfaces for the correct IP address, and do this up to 3
 	// times.
-	ipSet := false
-	for ipretries := 1; ipretries < 4; ipretries++ {
-		kuttilog.Printf(kuttilog.Info, "Fetching IP address (attempt %v/3)...", ipretries)
-
-		var ipaddress string
-		ipprops := []string{propIPAddress, propIPAddress2, propIPAddress3}
-
-		for _, ipprop := range ipprops {
-			ipaddr, present := newmachine.getproperty(ipprop)
-
-			if present {
-				ipaddr = trimpropend(ipaddr)
-				if ipRegex.MatchString(ipaddr) && strings.HasPrefix(ipaddr, ipNetAddr) {
-					ipaddress = ipaddr
-					break
-				}
-			}
-
-			if kuttilog.V(kuttilog.Debug) {
-				kuttilog.Printf(kuttilog.Debug, "value of property %v is %v, and present is %v.", ipprop, ipaddr, present)
-				kuttilog.Printf(kuttilog.Debug, "Regex match is %v, and prefix match is %v.", ipRegex.MatchString(ipaddr), strings.HasPrefix(ipaddr, ipNetAddr))
-			}
-		}
-
-		if ipaddress != "" {
-			kuttilog.Printf(kuttilog.Info, "Obtained IP address '%v'", ipaddress)
-			newmachine.setproperty(propSavedIPAddress, ipaddress)
-			ipSet = true
-			break
-		}
-
-		kuttilog.Printf(kuttilog.Info, "Failed. Waiting %v seconds before retry...", ipretries*10)
-		time.Sleep(time.Duration(ipretries*10) * time.Second)
+	ipaddress := fetchwithretries("IP address", newmachine.findipaddress)
+	if ipaddress != "" {
+		newmachine.setproperty(propSavedIPAddress, ipaddress)
+	} else {
+		kuttilog.Printf(0, "Error: Failed to get IP address. You may have to delete this node and recreate it manually.")
 	}
 
-	if !ipSet {
-		kuttilog.Printf(0, "Error: Failed to get IP address. You may have to delete this node and recreate it manually.")
+	// Save the IPv6 Address, if the network is dual-stack.
+	// IPv6 addresses may take longer to be reported, so the same
+	// retry pattern is used.
+	if ipv6prefix := newmachine.networkipv6prefix(); ipv6prefix != "" {
+		ipv6address := fetchwithretries("IPv6 address", func() string {
+			return newmachine.findipv6address(ipv6prefix)
+		})
+		if ipv6address != "" {
+			newmachine.setproperty(propSavedIPv6Address, ipv6address)
+			newmachine.savedipv6address = ipv6address
+		} else {
+			kuttilog.Printf(0, "Error: Failed to get IPv6 address. IPv6 port forwarding will not be available for this node.")
+		}
 	}
 
 	kuttilog.Println(kuttilog.Info, "Stopping host...")
@@ -0,0 +1,26 @@
+package drivervbox
+
+import "testing"
+
+func TestIsNetIPv6Address(t *testing.T) {
+	tests := []struct {
+		ipaddr   string
+		prefix   string
+		expected bool
+	}{
+		{"fd17:625c:f037:2::10", "fd17:625c:f037:2::/64", true},
+		{"fd17:625c:f037:3::10", "fd17:625c:f037:2::/64", false},
+		{"fd00:1::5", "fd00:1::/64", true},
+		{"fe80::a00:27ff:fe4e:66a1", "fd17:625c:f037:2::/64", false},
+		{"192.168.125.10", "fd17:625c:f037:2::/64", false},
+		{"::ffff:192.168.125.10", "::ffff:0:0/96", false},
+		{"not an address", "fd17:625c:f037:2::/64", false},
+		{"fd17:625c:f037:2::10", "", false},
+	}
+
+	for _, test := range tests {
+		if result := isnetipv6address(test.ipaddr, test.prefix); result != test.expected {
+			t.Errorf("isnetipv6address(%q, %q) = %v; want %v", test.ipaddr, test.prefix, result, test.expected)
+		}
+	}
+}
@@ -2,8 +2,10 @@ package drivervbox
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/kuttiproject/drivercore"
+	"github.com/kuttiproject/kuttilog"
 	"github.com/kuttiproject/workspace"
 )
 
@@ -15,6 +17,10 @@ func (vd *Driver) QualifiedNetworkName(clustername string) string {
 // DeleteNetwork deletes a network.
 // It does this by running the command:
 //   VBoxManage natnetwork remove --netname <networkname>
+// followed by:
+//   VBoxManage dhcpserver remove --netname <networkname>
+// Either part may already have been removed, in which case it is skipped.
+// So, DeleteNetwork can safely be called again if it fails partway.
 func (vd *Driver) DeleteNetwork(clustername string) error {
 	if !vd.validate() {
 		return vd
@@ -22,37 +28,55 @@ func (vd *Driver) DeleteNetwork(clustername string) error {
 
 	netname := vd.QualifiedNetworkName(clustername)
 
-	output, err := workspace.RunWithResults(
-		vd.vboxmanagepath,
-		"natnetwork",
-		"remove",
-		"--netname",
-		netname,
-	)
+	existingnetwork, err := vd.findnatnetwork(netname)
 	if err != nil {
-		return fmt.Errorf(
-			"could not delete NAT network %s:%v:%s",
+		return err
+	}
+
+	if existingnetwork != nil {
+		output, err := workspace.RunWithResults(
+			vd.vboxmanagepath,
+			"natnetwork",
+			"remove",
+			"--netname",
 			netname,
-			err,
-			output,
 		)
+		if err != nil {
+			return fmt.Errorf(
+				"could not delete NAT network %s:%v:%s",
+				netname,
+				err,
+				output,
+			)
+		}
+	} else {
+		kuttilog.Printf(kuttilog.Debug, "NAT network %s not found. Skipping.", netname)
 	}
 
 	// Associated dhcpserver must also be deleted
-	output, err = workspace.RunWithResults(
-		vd.vboxmanagepath,
-		"dhcpserver",
-		"remove",
-		"--netname",
-		netname,
-	)
+	existingdhcpserver, err := vd.finddhcpserver(netname)
 	if err != nil {
-		return fmt.Errorf(
-			"could not delete DHCP server %s:%v:%s",
+		return err
+	}
+
+	if existingdhcpserver != nil {
+		output, err := workspace.RunWithResults(
+			vd.vboxmanagepath,
+			"dhcpserver",
+			"remove",
+			"--netname",
 			netname,
-			err,
-			output,
 		)
+		if err != nil {
+			return fmt.Errorf(
+				"could not delete DHCP server %s:%v:%s",
+				netname,
+				err,
+				output,
+			)
+		}
+	} else {
+		kuttilog.Printf(kuttilog.Debug, "DHCP server for network %s not found. Skipping.", netname)
 	}
 
 	return nil
@@ -60,6 +84,14 @@ func (vd *Driver) DeleteNetwork(clustername string) error {
 
 // NewNetwork creates a new VirtualBox NAT network.
 // It uses the CIDR common to all Kutti networks, and is dhcp-enabled at start.
+// If EnableIPv6 is true, IPv6 is also enabled on the network, using the prefix
+// in DefaultNetIPv6Prefix. It does this by running the command:
+//   VBoxManage natnetwork add --netname <networkname> --network <cidr> --enable --dhcp on [--ipv6 on --ipv6-prefix <prefix>]
+// followed by:
+//   VBoxManage dhcpserver add --netname <networkname> --ip <dhcpaddress> --netmask <netmask> --lowerip <lowerip> --upperip <upperip> --enable
+// If the NAT network or the DHCP server already exist with the same settings,
+// they are adopted instead of being created. If they exist with different
+// settings, an error is returned.
 func (vd *Driver) NewNetwork(clustername string) (drivercore.Network, error) {
 	if !vd.validate() {
 		return nil, vd
@@ -67,11 +99,128 @@ func (vd *Driver) NewNetwork(clustername string) (drivercore.Network, error) {
 
 	netname := vd.QualifiedNetworkName(clustername)
 
+	ipv6prefix := ""
+	if EnableIPv6 {
+		ipv6prefix = DefaultNetIPv6Prefix
+	}
+
+	existingnetwork, err := vd.findnatnetwork(netname)
+	if err != nil {
+		return nil, err
+	}
+
+	if existingnetwork != nil {
+		if existingnetwork.netCIDR != DefaultNetCIDR || existingnetwork.ipv6Prefix != ipv6prefix {
+			return nil, fmt.Errorf(
+				"NAT network %s already exists with different settings (network %s, IPv6 prefix '%s')",
+				netname,
+				existingnetwork.netCIDR,
+				existingnetwork.ipv6Prefix,
+			)
+		}
+		kuttilog.Printf(kuttilog.Info, "Using existing NAT network %s.", netname)
+
+		if !existingnetwork.enabled {
+			output, err := workspace.RunWithResults(
+				vd.vboxmanagepath,
+				"natnetwork",
+				"modify",
+				"--netname",
+				netname,
+				"--enable",
+			)
+			if err != nil {
+				return nil, fmt.Errorf(
+					"could not enable existing NAT network %s:%v:%s",
+					netname,
+					err,
+					output,
+				)
+			}
+			existingnetwork.enabled = true
+		}
+	} else {
+		err = vd.addnatnetwork(netname, ipv6prefix)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Hard-coding a thirty-node limit for now
+	dhcpsettings := &DHCPServerInfo{
+		Enabled: true,
+		IP:      dhcpaddress,
+		Netmask: dhcpnetmask,
+		LowerIP: fmt.Sprintf("%s.%d", ipNetAddr, iphostbase),
+		UpperIP: fmt.Sprintf("%s.%d", ipNetAddr, iphostbase+29),
+	}
+
+	existingdhcpserver, err := vd.finddhcpserver(netname)
+	if err != nil {
+		return nil, err
+	}
+
+	if existingdhcpserver != nil {
+		if existingdhcpserver.IP != dhcpsettings.IP ||
+			existingdhcpserver.Netmask != dhcpsettings.Netmask ||
+			existingdhcpserver.LowerIP != dhcpsettings.LowerIP ||
+			existingdhcpserver.UpperIP != dhcpsettings.UpperIP {
+			return nil, fmt.Errorf(
+				"DHCP server for network %s already exists with different settings (%s, range %s-%s)",
+				netname,
+				existingdhcpserver.IP,
+				existingdhcpserver.LowerIP,
+				existingdhcpserver.UpperIP,
+			)
+		}
+		kuttilog.Printf(kuttilog.Info, "Using existing DHCP server for network %s.", netname)
+
+		if !existingdhcpserver.Enabled {
+			output, err := workspace.RunWithResults(
+				vd.vboxmanagepath,
+				"dhcpserver",
+				"modify",
+				"--netname",
+				netname,
+				"--enable",
+			)
+			if err != nil {
+				return nil, fmt.Errorf(
+					"could not enable existing DHCP server for network %s:%v:%s",
+					netname,
+					err,
+					output,
+				)
+			}
+		}
+	} else {
+		err = vd.adddhcpserver(netname, dhcpsettings)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	newnetwork := &Network{
+		name:       netname,
+		netCIDR:    DefaultNetCIDR,
+		ipv6Prefix: ipv6prefix,
+		enabled:    true,
+		gateway:    fmt.Sprintf("%s.%d", ipNetAddr, 1),
+		dhcp:       dhcpsettings,
+	}
+	if existingnetwork != nil {
+		newnetwork = existingnetwork
+		newnetwork.dhcp = dhcpsettings
+	}
+
+	return newnetwork, nil
+}
+
+func (vd *Driver) addnatnetwork(netname string, ipv6prefix string) error {
 	// Multiple VirtualBox NAT Networks can have the same IP range
 	// So, all Kutti networks will use the same network CIDR
 	// We start with dhcp enabled.
-	output, err := workspace.RunWithResults(
-		vd.vboxmanagepath,
+	params := []string{
 		"natnetwork",
 		"add",
 		"--netname",
@@ -81,9 +230,25 @@ func (vd *Driver) NewNetwork(clustername string) (drivercore.Network, error) {
 		"--enable",
 		"--dhcp",
 		"on",
+	}
+
+	// IPv6 is optional, and uses a common prefix in the same way
+	if ipv6prefix != "" {
+		params = append(
+			params,
+			"--ipv6",
+			"on",
+			"--ipv6-prefix",
+			ipv6prefix,
+		)
+	}
+
+	output, err := workspace.RunWithResults(
+		vd.vboxmanagepath,
+		params...,
 	)
 	if err != nil {
-		return nil, fmt.Errorf(
+		return fmt.Errorf(
 			"could not create NAT network %s:%v:%s",
 			netname,
 			err,
@@ -91,26 +256,29 @@ func (vd *Driver) NewNetwork(clustername string) (drivercore.Network, error) {
 		)
 	}
 
+	return nil
+}
+
+func (vd *Driver) adddhcpserver(netname string, settings *DHCPServerInfo) error {
 	// Manually create the associated DHCP server
-	// Hard-coding a thirty-node limit for now
-	output, err = workspace.RunWithResults(
+	output, err := workspace.RunWithResults(
 		vd.vboxmanagepath,
 		"dhcpserver",
 		"add",
 		"--netname",
 		netname,
 		"--ip",
-		dhcpaddress,
+		settings.IP,
 		"--netmask",
-		dhcpnetmask,
+		settings.Netmask,
 		"--lowerip",
-		fmt.Sprintf("%s.%d", ipNetAddr, iphostbase),
+		settings.LowerIP,
 		"--upperip",
-		fmt.Sprintf("%s.%d", ipNetAddr, iphostbase+29),
+		settings.UpperIP,
 		"--enable",
 	)
 	if err != nil {
-		return nil, fmt.Errorf(
+		return fmt.Errorf(
 			"could not create DHCP server for network %s:%v:%s",
 			netname,
 			err,
@@ -118,10 +286,161 @@ func (vd *Driver) NewNetwork(clustername string) (drivercore.Network, error) {
 		)
 	}
 
-	newnetwork := &Network{
-		name:    netname,
-		netCIDR: DefaultNetCIDR,
+	return nil
+}
+
+// findnatnetwork returns the named NAT network, or nil if it does not exist.
+// It does this by running the command:
+//   VBoxManage natnetwork list <networkname>
+func (vd *Driver) findnatnetwork(netname string) (*Network, error) {
+	output, err := workspace.RunWithResults(
+		vd.vboxmanagepath,
+		"natnetwork",
+		"list",
+		netname,
+	)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"could not list NAT network %s:%v:%s",
+			netname,
+			err,
+			output,
+		)
+	}
+
+	for _, network := range parsenatnetworks(output) {
+		if network.name == netname {
+			return network, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// finddhcpserver returns the DHCP server of the named network, or nil if it
+// does not exist.
+// It does this by running the command:
+//   VBoxManage list dhcpservers
+func (vd *Driver) finddhcpserver(netname string) (*DHCPServerInfo, error) {
+	output, err := workspace.RunWithResults(
+		vd.vboxmanagepath,
+		"list",
+		"dhcpservers",
+	)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"could not list DHCP servers:%v:%s",
+			err,
+			output,
+		)
+	}
+
+	return parsedhcpservers(output)[netname], nil
+}
+
+// GetNetwork returns the full details of a cluster's NAT network, or an error.
+// It does this by running the commands:
+//   VBoxManage natnetwork list <networkname>
+//   VBoxManage list dhcpservers
+// and then, for each machine in the cluster attached to the network:
+//   VBoxManage dhcpserver findlease --netname <networkname> --mac-address <mac>
+func (vd *Driver) GetNetwork(clustername string) (*Network, error) {
+	if !vd.validate() {
+		return nil, vd
+	}
+
+	netname := vd.QualifiedNetworkName(clustername)
+
+	result, err := vd.findnatnetwork(netname)
+	if err != nil {
+		return nil, err
+	}
+	if result == nil {
+		return nil, fmt.Errorf("network %s not found", netname)
+	}
+
+	result.dhcp, err = vd.finddhcpserver(netname)
+	if err != nil {
+		return nil, err
+	}
+
+	if result.dhcp != nil {
+		result.leases, err = vd.networkleases(clustername, netname)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// maxNICs is the number of network adapters checked on each machine.
+const maxNICs = 8
+
+func (vd *Driver) networkleases(clustername string, netname string) ([]DHCPLease, error) {
+	machinenames, infos, err := vd.clustermachineinfo(clustername)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := vd.QualifiedMachineName("", clustername)
+	result := []DHCPLease{}
+	for _, qualifiedmachinename := range machinenames {
+		for _, mac := range networkmacaddresses(infos[qualifiedmachinename], netname) {
+			output, err := workspace.RunWithResults(
+				vd.vboxmanagepath,
+				"dhcpserver",
+				"findlease",
+				"--netname",
+				netname,
+				"--mac-address",
+				mac,
+			)
+			// No lease is not an error
+			if err != nil {
+				continue
+			}
+
+			lease, ok := parsedhcplease(output)
+			if ok {
+				lease.MachineName = strings.TrimPrefix(qualifiedmachinename, prefix)
+				result = append(result, lease)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// networkmacaddresses returns the MAC addresses of the network adapters
+// of a VM that are attached to the specified NAT network, from its
+// machine-readable details.
+func networkmacaddresses(info map[string]string, netname string) []string {
+	result := []string{}
+	for nic := 1; nic <= maxNICs; nic++ {
+		if info[fmt.Sprintf("nic%d", nic)] != "natnetwork" ||
+			info[fmt.Sprintf("nat-network%d", nic)] != netname {
+			continue
+		}
+
+		result = append(result, formatmacaddress(info[fmt.Sprintf("macaddress%d", nic)]))
+	}
+
+	return result
+}
+
+// formatmacaddress converts a MAC address in the VirtualBox format
+// 080027AABBCC to the colon-separated format 08:00:27:aa:bb:cc.
+func formatmacaddress(mac string) string {
+	if len(mac) != 12 {
+		return mac
+	}
+
+	mac = strings.ToLower(mac)
+	parts := make([]string, 6)
+	for i := range parts {
+		parts[i] = mac[i*2 : i*2+2]
 	}
 
-	return newnetwork, err
+	return strings.Join(parts, ":")
 }
@@ -2,6 +2,7 @@ package drivervbox
 
 import (
 	"fmt"
+	"runtime/debug"
 
 	"github.com/kuttiproject/workspace"
 )
@@ -18,9 +19,60 @@ const (
 	forwardedPortBase  = 10000
 )
 
+// DriverVersion is the version of this driver. It is recorded on
+// every Machine created by the driver. By default, it is the version of
+// this module in the build information of the program. It can also be
+// set at link time, using:
+//   -ldflags "-X github.com/kuttiproject/driver-vbox.DriverVersion=<version>"
+var DriverVersion string
+
+const driverModulePath = "github.com/kuttiproject/driver-vbox"
+
+func init() {
+	// A version set at link time takes precedence
+	if DriverVersion == "" {
+		DriverVersion = buildinfoversion()
+	}
+}
+
+// buildinfoversion returns the version of this module recorded in the
+// build information, or "(devel)" if it is not known.
+func buildinfoversion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "(devel)"
+	}
+
+	if info.Main.Path == driverModulePath && info.Main.Version != "" {
+		return info.Main.Version
+	}
+
+	for _, dep := range info.Deps {
+		if dep.Path != driverModulePath {
+			continue
+		}
+		if dep.Replace != nil && dep.Replace.Version != "" {
+			return dep.Replace.Version
+		}
+		return dep.Version
+	}
+
+	return "(devel)"
+}
+
 // DefaultNetCIDR is the address range used by NAT networks.
 var DefaultNetCIDR = "192.168.125.0/24"
 
+// EnableIPv6 controls whether new NAT networks are created dual-stack.
+// When true, NewNetwork enables IPv6 on the network using the prefix in
+// DefaultNetIPv6Prefix. NewMachine saves the IPv6 address of new machines
+// whose network has IPv6 enabled, whatever its prefix.
+var EnableIPv6 = false
+
+// DefaultNetIPv6Prefix is the IPv6 prefix used by NAT networks when
+// EnableIPv6 is true.
+var DefaultNetIPv6Prefix = "fd17:625c:f037:2::/64"
+
 // Driver implements the drivercore.Driver interface for VirtualBox.
 type Driver struct {
 	vboxmanagepath string
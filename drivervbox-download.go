@@ -0,0 +1,272 @@
+package drivervbox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/kuttiproject/kuttilog"
+)
+
+// downloadstate records what a partially downloaded file was downloaded
+// from, so that the download can be resumed safely. It is saved next to
+// the partial file, with a .state suffix.
+type downloadstate struct {
+	URL          string
+	ETag         string
+	LastModified string
+}
+
+func downloadstatepath(filepath string) string {
+	return filepath + ".state"
+}
+
+func loaddownloadstate(filepath string) (*downloadstate, error) {
+	data, err := os.ReadFile(downloadstatepath(filepath))
+	if err != nil {
+		return nil, err
+	}
+
+	result := &downloadstate{}
+	err = json.Unmarshal(data, result)
+	return result, err
+}
+
+func savedownloadstate(filepath string, state *downloadstate) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(downloadstatepath(filepath), data, 0644)
+}
+
+// downloadfile downloads a URL into a file, resuming a previous partial
+// download into the same file if possible.
+// A partial download is resumed with an HTTP Range request, only if the
+// state saved with it matches the URL, and the server has an ETag or
+// Last-Modified validator for it. The validator is sent via If-Range, so
+// the server sends the whole file again if it has changed. If the server
+// sends a range that does not start at the end of the partial file, the
+// partial file is discarded and the download restarted.
+// The progress callback, if not nil, reports current and total bytes,
+// including any bytes downloaded earlier.
+// The hasher, if not nil, is fed the entire file contents as they are
+// written, including any bytes downloaded earlier. This avoids a separate
+// pass over the file to compute its checksum.
+func downloadfile(url string, filepath string, progress func(int64, int64), hasher hash.Hash) error {
+	var offset int64
+	var validator string
+
+	state, err := loaddownloadstate(filepath)
+	if err == nil && state.URL == url {
+		if info, err := os.Stat(filepath); err == nil {
+			offset = info.Size()
+		}
+
+		validator = state.ETag
+		if validator == "" {
+			validator = state.LastModified
+		}
+	}
+	if validator == "" {
+		offset = 0
+	}
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		kuttilog.Printf(kuttilog.Info, "Resuming download from byte %v...", offset)
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", validator)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var total int64
+	flags := os.O_CREATE | os.O_WRONLY
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		// The range sent must continue exactly where the partial file ends
+		if start := contentrangestart(resp.Header.Get("Content-Range")); start != offset {
+			if offset == 0 {
+				return fmt.Errorf("could not download %s: server sent unexpected range %s", url, resp.Header.Get("Content-Range"))
+			}
+
+			kuttilog.Printf(kuttilog.Info, "Server sent a range starting at byte %v instead of %v. Restarting download...", start, offset)
+			resp.Body.Close()
+			discarddownload(filepath)
+			return downloadfile(url, filepath, progress, hasher)
+		}
+
+		total = contentrangetotal(resp.Header.Get("Content-Range"))
+		flags |= os.O_APPEND
+
+		// Only the partial file needs to be read again
+		err = hashfile(filepath, hasher)
+		if err != nil {
+			return err
+		}
+	case http.StatusOK:
+		if offset > 0 {
+			kuttilog.Println(kuttilog.Info, "File has changed on the server. Restarting download...")
+		}
+		offset = 0
+		total = resp.ContentLength
+		flags |= os.O_TRUNC
+	case http.StatusRequestedRangeNotSatisfiable:
+		// The partial file may already be complete
+		total = contentrangetotal(resp.Header.Get("Content-Range"))
+		if offset > 0 && total == offset {
+			err = hashfile(filepath, hasher)
+			if err != nil {
+				return err
+			}
+			if progress != nil {
+				progress(offset, total)
+			}
+			return os.Remove(downloadstatepath(filepath))
+		}
+		os.Remove(downloadstatepath(filepath))
+		return fmt.Errorf("could not resume download of %s: server returned %s", url, resp.Status)
+	default:
+		return fmt.Errorf("could not download %s: server returned %s", url, resp.Status)
+	}
+
+	err = savedownloadstate(filepath, &downloadstate{
+		URL:          url,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	})
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(filepath, flags, 0644)
+	if err != nil {
+		return err
+	}
+
+	var src io.Reader = resp.Body
+	if progress != nil {
+		src = &progressreader{
+			reader:   resp.Body,
+			current:  offset,
+			total:    total,
+			progress: progress,
+		}
+	}
+
+	var dest io.Writer = f
+	if hasher != nil {
+		dest = io.MultiWriter(f, hasher)
+	}
+
+	_, err = io.Copy(dest, src)
+	closeerr := f.Close()
+	if err != nil {
+		// The partial file and its state are kept, so that the
+		// download can be resumed later.
+		return err
+	}
+	if closeerr != nil {
+		return closeerr
+	}
+
+	err = os.Remove(downloadstatepath(filepath))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// hashfile feeds the contents of a file to a hasher, if it is not nil.
+func hashfile(filepath string, hasher hash.Hash) error {
+	if hasher == nil {
+		return nil
+	}
+
+	f, err := os.Open(filepath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(hasher, f)
+	return err
+}
+
+// discarddownload removes a partially or fully downloaded file, and its
+// saved state.
+func discarddownload(filepath string) {
+	os.Remove(filepath)
+	os.Remove(downloadstatepath(filepath))
+}
+
+// contentrangestart returns the start of the range from a Content-Range
+// header in the format "bytes <start>-<end>/<total>", or -1 if it is not
+// known.
+func contentrangestart(contentrange string) int64 {
+	rangestr, found := strings.CutPrefix(contentrange, "bytes ")
+	if !found {
+		return -1
+	}
+
+	startstr, _, found := strings.Cut(rangestr, "-")
+	if !found {
+		return -1
+	}
+
+	start, err := strconv.ParseInt(startstr, 10, 64)
+	if err != nil {
+		return -1
+	}
+
+	return start
+}
+
+// contentrangetotal returns the total size from a Content-Range header
+// in the format "bytes <start>-<end>/<total>" or "bytes */<total>", or
+// -1 if it is not known.
+func contentrangetotal(contentrange string) int64 {
+	_, totalstr, found := strings.Cut(contentrange, "/")
+	if !found {
+		return -1
+	}
+
+	total, err := strconv.ParseInt(totalstr, 10, 64)
+	if err != nil {
+		return -1
+	}
+
+	return total
+}
+
+// progressreader reports progress as it is read from.
+type progressreader struct {
+	reader   io.Reader
+	current  int64
+	total    int64
+	progress func(int64, int64)
+}
+
+func (pr *progressreader) Read(p []byte) (int, error) {
+	n, err := pr.reader.Read(p)
+	pr.current += int64(n)
+	pr.progress(pr.current, pr.total)
+	return n, err
+}
@@ -0,0 +1,210 @@
+package drivervbox
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newtestdownloadserver(content *[]byte, etag *string, requests *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests = append(*requests, r.Header.Get("Range"))
+		w.Header().Set("ETag", *etag)
+		http.ServeContent(w, r, "image.ova", time.Time{}, bytes.NewReader(*content))
+	}))
+}
+
+func TestDownloadFileResume(t *testing.T) {
+	content := []byte(strings.Repeat("0123456789", 1000))
+	etag := `"v1"`
+	requests := []string{}
+
+	server := newtestdownloadserver(&content, &etag, &requests)
+	defer server.Close()
+
+	target := filepath.Join(t.TempDir(), "image.ovadownload")
+
+	// Simulate an interrupted download
+	err := os.WriteFile(target, content[:4000], 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = savedownloadstate(target, &downloadstate{URL: server.URL, ETag: etag})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var lastcurrent, lasttotal int64
+	hasher := sha256.New()
+	err = downloadfile(server.URL, target, func(current int64, total int64) {
+		lastcurrent, lasttotal = current, total
+	}, hasher)
+	if err != nil {
+		t.Fatalf("download failed: %v", err)
+	}
+
+	if len(requests) != 1 || requests[0] != "bytes=4000-" {
+		t.Errorf("expected one range request, got %q", requests)
+	}
+
+	result, _ := os.ReadFile(target)
+	if !bytes.Equal(result, content) {
+		t.Errorf("resumed file does not match: got %d bytes", len(result))
+	}
+
+	expectedsum := sha256.Sum256(content)
+	if !bytes.Equal(hasher.Sum(nil), expectedsum[:]) {
+		t.Errorf("checksum computed during resumed download does not match")
+	}
+
+	if lastcurrent != int64(len(content)) || lasttotal != int64(len(content)) {
+		t.Errorf("unexpected final progress %d/%d", lastcurrent, lasttotal)
+	}
+
+	if _, err := os.Stat(downloadstatepath(target)); !os.IsNotExist(err) {
+		t.Errorf("download state was not removed after completion")
+	}
+}
+
+func TestDownloadFileRestartsWhenChanged(t *testing.T) {
+	content := []byte(strings.Repeat("abcdefghij", 1000))
+	etag := `"v2"`
+	requests := []string{}
+
+	server := newtestdownloadserver(&content, &etag, &requests)
+	defer server.Close()
+
+	target := filepath.Join(t.TempDir(), "image.ovadownload")
+
+	// The partial file came from an older version
+	err := os.WriteFile(target, []byte(strings.Repeat("x", 4000)), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = savedownloadstate(target, &downloadstate{URL: server.URL, ETag: `"v1"`})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hasher := sha256.New()
+	err = downloadfile(server.URL, target, nil, hasher)
+	if err != nil {
+		t.Fatalf("download failed: %v", err)
+	}
+
+	expectedsum := sha256.Sum256(content)
+	if !bytes.Equal(hasher.Sum(nil), expectedsum[:]) {
+		t.Errorf("checksum computed during restarted download does not match")
+	}
+
+	result, _ := os.ReadFile(target)
+	if !bytes.Equal(result, content) {
+		t.Errorf("restarted file does not match: got %d bytes", len(result))
+	}
+}
+
+func TestDownloadFileWithoutState(t *testing.T) {
+	content := []byte(strings.Repeat("klmnopqrst", 100))
+	etag := `"v1"`
+	requests := []string{}
+
+	server := newtestdownloadserver(&content, &etag, &requests)
+	defer server.Close()
+
+	target := filepath.Join(t.TempDir(), "image.ovadownload")
+
+	// A stale partial file without state must not be resumed
+	err := os.WriteFile(target, []byte("stale"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = downloadfile(server.URL, target, nil, nil)
+	if err != nil {
+		t.Fatalf("download failed: %v", err)
+	}
+
+	if len(requests) != 1 || requests[0] != "" {
+		t.Errorf("expected one full request, got %q", requests)
+	}
+
+	result, _ := os.ReadFile(target)
+	if !bytes.Equal(result, content) {
+		t.Errorf("downloaded file does not match: got %d bytes", len(result))
+	}
+}
+
+func TestDownloadFileRestartsOnWrongRange(t *testing.T) {
+	content := []byte(strings.Repeat("uvwxyz0123", 1000))
+	requests := []string{}
+
+	// This server always sends the range from byte 2000, whatever was asked
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Header.Get("Range"))
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("Range") == "" {
+			w.Write(content)
+			return
+		}
+
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes 2000-%d/%d", len(content)-1, len(content)))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(content[2000:])
+	}))
+	defer server.Close()
+
+	target := filepath.Join(t.TempDir(), "image.ovadownload")
+
+	err := os.WriteFile(target, content[:4000], 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = savedownloadstate(target, &downloadstate{URL: server.URL, ETag: `"v1"`})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hasher := sha256.New()
+	err = downloadfile(server.URL, target, nil, hasher)
+	if err != nil {
+		t.Fatalf("download failed: %v", err)
+	}
+
+	if len(requests) != 2 || requests[0] != "bytes=4000-" || requests[1] != "" {
+		t.Errorf("expected a range request followed by a full request, got %q", requests)
+	}
+
+	result, _ := os.ReadFile(target)
+	if !bytes.Equal(result, content) {
+		t.Errorf("restarted file does not match: got %d bytes", len(result))
+	}
+
+	expectedsum := sha256.Sum256(content)
+	if !bytes.Equal(hasher.Sum(nil), expectedsum[:]) {
+		t.Errorf("checksum computed during restarted download does not match")
+	}
+}
+
+func TestContentRangeStart(t *testing.T) {
+	tests := map[string]int64{
+		"bytes 4000-9999/10000": 4000,
+		"bytes 0-9/10":          0,
+		"bytes 4000-9999/*":     4000,
+		"bytes */10000":         -1,
+		"items 4000-9999/10000": -1,
+		"":                      -1,
+	}
+
+	for contentrange, expected := range tests {
+		if result := contentrangestart(contentrange); result != expected {
+			t.Errorf("contentrangestart(%q) = %d; want %d", contentrange, result, expected)
+		}
+	}
+}
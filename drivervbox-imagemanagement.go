@@ -1,9 +1,17 @@
 package drivervbox
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"hash"
+	"os"
 	"path"
+	"regexp"
+	"sort"
+	"strings"
 
 	"github.com/kuttiproject/drivercore"
 	"github.com/kuttiproject/kuttilog"
@@ -69,6 +77,22 @@ func imagepathfromk8sversion(k8sversion string) (string, error) {
 	return result, nil
 }
 
+// imagechecksumfromk8sversion returns the checksum of the image for
+// the specified Kubernetes version, or an empty string if unknown.
+func imagechecksumfromk8sversion(k8sversion string) string {
+	err := imageconfigmanager.Load()
+	if err != nil {
+		return ""
+	}
+
+	img, ok := imagedata.images[k8sversion]
+	if !ok {
+		return ""
+	}
+
+	return img.imageChecksum
+}
+
 func addfromfile(k8sversion string, filepath string, checksum string) error {
 	kuttilog.Println(kuttilog.Info, "Checking image validity...")
 	filechecksum, err := workspace.ChecksumFile(filepath)
@@ -97,61 +121,268 @@ func addfromfile(k8sversion string, filepath string, checksum string) error {
 	return nil
 }
 
-func removefile(k8sversion string) error {
-	filename, err := imagepathfromk8sversion(k8sversion)
+// newchecksumhasher returns a hasher that computes the same checksum
+// as workspace.ChecksumFile.
+func newchecksumhasher() hash.Hash {
+	return sha256.New()
+}
+
+func checksumstring(hasher hash.Hash) string {
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+// addfromdownload moves a downloaded file into the cache, if its
+// checksum, computed during download, is valid. The download is in
+// the cache directory, so the move is an atomic rename.
+// The downloaded file is removed in either case.
+func addfromdownload(k8sversion string, downloadpath string, checksum string, filechecksum string) error {
+	defer discarddownload(downloadpath)
+
+	kuttilog.Println(kuttilog.Info, "Checking image validity...")
+	if filechecksum != checksum {
+		kuttilog.Printf(kuttilog.Debug, "checksum for file %v failed.\nWanted: %v\nGot   : %v\n", downloadpath, checksum, filechecksum)
+		return errors.New("file  is not valid")
+	}
+
+	localfilepath, err := imagepathfromk8sversion(k8sversion)
 	if err != nil {
 		return err
 	}
 
-	return workspace.RemoveFile(filename)
+	return os.Rename(downloadpath, localfilepath)
 }
 
-func fetchimagelist() error {
-	// Download image list into temp directory
-	confdir, _ := vboxConfigDir()
-	tempfilename := "vboximagesnewlist.json"
-	tempfilepath := path.Join(confdir, tempfilename)
+var imagetagpattern, _ = regexp.Compile(`^[A-Za-z0-9][A-Za-z0-9._+-]*$`)
 
-	kuttilog.Printf(kuttilog.Debug, "confdir: %v\ntempfilepath: %v\n", confdir, tempfilepath)
+// checklocalimagetag returns an error if a tag is not valid for a local
+// image, or if it would shadow an image obtained from an image source.
+func checklocalimagetag(tag string, images map[string]*Image) error {
+	if !imagetagpattern.MatchString(tag) {
+		return fmt.Errorf("invalid image tag '%s'", tag)
+	}
 
-	kuttilog.Println(kuttilog.Info, "Fetching image list...")
-	kuttilog.Printf(kuttilog.Debug, "Fetching from %v into %v.", ImagesSourceURL, tempfilepath)
-	err := workspace.DownloadFile(ImagesSourceURL, tempfilepath)
-	kuttilog.Printf(kuttilog.Debug, "Error: %v", err)
+	if existing, ok := images[tag]; ok && !existing.imageLocal {
+		return fmt.Errorf("an image for K8s version %s already exists from %s", tag, existing.imageSource)
+	}
+
+	return nil
+}
+
+// addlocalimage copies a locally built image file into the cache under
+// the specified tag, computing its checksum on the way, and returns a new
+// image entry for it.
+func addlocalimage(tag string, filepath string) (*Image, error) {
+	cachedir, err := vboxCacheDir()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer workspace.RemoveFile(tempfilepath)
 
-	// Load into object
-	tempimagedata := &imageconfigdata{}
-	tempconfigmanager, err := workspace.NewFileConfigManager(tempfilename, tempimagedata)
+	kuttilog.Println(kuttilog.Info, "Copying image to local cache...")
+	importpath := path.Join(cachedir, fmt.Sprintf("kutti-k8s-%s.ovaimport", tag))
+	hasher := newchecksumhasher()
+	err = copylocalfile(filepath, importpath, nil, hasher)
 	if err != nil {
-		return err
+		discarddownload(importpath)
+		return nil, err
 	}
 
-	err = tempconfigmanager.Load()
+	checksum := checksumstring(hasher)
+	err = addfromdownload(tag, importpath, checksum, checksum)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Image{
+		imageK8sVersion: tag,
+		imageChecksum:   checksum,
+		imageSourceURL:  filepath,
+		imageStatus:     drivercore.ImageStatusDownloaded,
+		imageLocal:      true,
+	}, nil
+}
+
+func removefile(k8sversion string) error {
+	filename, err := imagepathfromk8sversion(k8sversion)
 	if err != nil {
 		return err
 	}
 
-	// Compare against current and update
-	for key, newimage := range tempimagedata.images {
-		oldimage := imagedata.images[key]
-		if oldimage != nil &&
-			newimage.imageChecksum == oldimage.imageChecksum &&
-			newimage.imageSourceURL == oldimage.imageSourceURL &&
-			oldimage.imageStatus == drivercore.ImageStatusDownloaded {
+	return workspace.RemoveFile(filename)
+}
 
-			newimage.imageStatus = drivercore.ImageStatusDownloaded
+func fetchimagelist(removeunreferenced bool) (*ImageListChanges, error) {
+	// Current local state is needed to carry it over
+	err := imageconfigmanager.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	kuttilog.Println(kuttilog.Info, "Fetching image list...")
+
+	// Fetch from all sources, failing over to the next source
+	// if one cannot be reached or verified
+	sources := imagesources()
+	lists := []map[string]*Image{}
+	sourceerrors := []string{}
+	failedsources := map[string]bool{}
+	for index, source := range sources {
+		list, err := fetchsourceimagelist(index, source)
+		if err != nil {
+			kuttilog.Printf(kuttilog.Info, "Could not fetch image list from %v: %v. Keeping its images as they were.", source, err)
+			sourceerrors = append(sourceerrors, fmt.Sprintf("%v: %v", source, err))
+			failedsources[source] = true
+			continue
 		}
+		lists = append(lists, list)
+	}
+
+	if len(lists) == 0 {
+		return nil, fmt.Errorf("could not fetch image list from any source: %s", strings.Join(sourceerrors, "; "))
 	}
 
+	newimages := mergeimagelists(lists)
+	changes := mergeimagestate(imagedata.images, newimages, failedsources)
+
 	// Make it current
-	imagedata.images = tempimagedata.images
+	imagedata.images = newimages
+
+	// Remove image files that no current entry refers to
+	if removeunreferenced {
+		changes.FilesRemoved, err = removeunreferencedfiles()
+		if err != nil {
+			return changes, err
+		}
+	}
 
 	// Save as local configuration
-	imageconfigmanager.Save()
+	return changes, imageconfigmanager.Save()
+}
 
-	return nil
+// mergeimagestate carries local state from the current image list over
+// to a newly fetched one, and reports the differences between them.
+// Images from sources that could not be fetched this time are carried over
+// unchanged, and not reported as removed or retired.
+func mergeimagestate(oldimages map[string]*Image, newimages map[string]*Image, failedsources map[string]bool) *ImageListChanges {
+	changes := &ImageListChanges{}
+
+	for key, newimage := range newimages {
+		oldimage, ok := oldimages[key]
+		if !ok || oldimage.imageLocal {
+			if !ok {
+				changes.Added = append(changes.Added, key)
+			}
+			continue
+		}
+
+		if newimage.imageChecksum != oldimage.imageChecksum {
+			// The cached file, if any, is stale
+			changes.Changed = append(changes.Changed, key)
+		} else {
+			// A changed URL does not invalidate the cached file
+			if newimage.imageSourceURL != oldimage.imageSourceURL {
+				changes.Changed = append(changes.Changed, key)
+			}
+			newimage.imageStatus = oldimage.imageStatus
+		}
+
+		if newimage.imageDeprecated && !oldimage.imageDeprecated {
+			changes.Deprecated = append(changes.Deprecated, key)
+		}
+	}
+
+	for key, oldimage := range oldimages {
+		// Locally registered images are not in any source, and must be kept
+		if oldimage.imageLocal {
+			if newimage, ok := newimages[key]; ok {
+				kuttilog.Printf(kuttilog.Info, "Local image %s hides an image with the same version from %s.", key, newimage.imageSource)
+			}
+			newimages[key] = oldimage
+			continue
+		}
+
+		if _, ok := newimages[key]; ok {
+			continue
+		}
+
+		// Not known to be removed, because its source could not be fetched
+		if failedsources[oldimage.imageSource] {
+			newimages[key] = oldimage
+			continue
+		}
+
+		// Removed upstream. If downloaded, keep as retired, so that
+		// the cached file is still known.
+		if oldimage.imageStatus == drivercore.ImageStatusDownloaded {
+			if !oldimage.imageRetired {
+				changes.Retired = append(changes.Retired, key)
+			}
+			oldimage.imageRetired = true
+			newimages[key] = oldimage
+		} else {
+			changes.Removed = append(changes.Removed, key)
+		}
+	}
+
+	sort.Strings(changes.Added)
+	sort.Strings(changes.Removed)
+	sort.Strings(changes.Retired)
+	sort.Strings(changes.Deprecated)
+	sort.Strings(changes.Changed)
+
+	return changes
+}
+
+// removeunreferencedfiles removes image files in the cache that do not
+// belong to a downloaded image in the current list. This includes stale
+// files of images whose checksum has changed. Files of images that
+// machines were created from are kept. It returns the names of removed
+// files.
+func removeunreferencedfiles() ([]string, error) {
+	cachedir, err := vboxCacheDir()
+	if err != nil {
+		return nil, err
+	}
+
+	imagemachines, err := machinesbyimage()
+	if err != nil {
+		return nil, err
+	}
+
+	referenced := map[string]bool{}
+	for key, image := range imagedata.images {
+		if image.imageStatus == drivercore.ImageStatusDownloaded {
+			referenced[imagenamefromk8sversion(key)] = true
+		}
+	}
+
+	entries, err := os.ReadDir(cachedir)
+	if err != nil {
+		return nil, err
+	}
+
+	removed := []string{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, "kutti-") || !strings.HasSuffix(name, ".ova") {
+			continue
+		}
+		if referenced[name] {
+			continue
+		}
+
+		k8sversion := strings.TrimSuffix(strings.TrimPrefix(name, "kutti-"), ".ova")
+		if err := checkimagenotinuse(k8sversion, imagemachines); err != nil {
+			kuttilog.Printf(kuttilog.Info, "Keeping unreferenced image file %s: %v", name, err)
+			continue
+		}
+
+		kuttilog.Printf(kuttilog.Info, "Removing unreferenced image file %s...", name)
+		err = workspace.RemoveFile(path.Join(cachedir, name))
+		if err != nil {
+			return removed, err
+		}
+		removed = append(removed, name)
+	}
+
+	return removed, nil
 }
@@ -0,0 +1,113 @@
+package drivervbox
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kuttiproject/drivercore"
+)
+
+func TestMergeImageState(t *testing.T) {
+	oldimages := map[string]*Image{
+		"1.30": {imageK8sVersion: "1.30", imageChecksum: "a", imageStatus: drivercore.ImageStatusDownloaded},
+		"1.31": {imageK8sVersion: "1.31", imageChecksum: "b", imageSourceURL: "old", imageStatus: drivercore.ImageStatusDownloaded},
+		"1.32": {imageK8sVersion: "1.32", imageChecksum: "c", imageStatus: drivercore.ImageStatusDownloaded},
+		"1.29": {imageK8sVersion: "1.29", imageChecksum: "d", imageStatus: drivercore.ImageStatusNotDownloaded},
+		"mine": {imageK8sVersion: "mine", imageChecksum: "e", imageStatus: drivercore.ImageStatusDownloaded, imageLocal: true},
+	}
+	newimages := map[string]*Image{
+		"1.31": {imageK8sVersion: "1.31", imageChecksum: "b", imageSourceURL: "new", imageDeprecated: true, imageStatus: drivercore.ImageStatusNotDownloaded},
+		"1.32": {imageK8sVersion: "1.32", imageChecksum: "changed", imageStatus: drivercore.ImageStatusNotDownloaded},
+		"1.33": {imageK8sVersion: "1.33", imageChecksum: "f", imageStatus: drivercore.ImageStatusNotDownloaded},
+	}
+
+	changes := mergeimagestate(oldimages, newimages, map[string]bool{})
+
+	expected := &ImageListChanges{
+		Added:      []string{"1.33"},
+		Removed:    []string{"1.29"},
+		Retired:    []string{"1.30"},
+		Deprecated: []string{"1.31"},
+		Changed:    []string{"1.31", "1.32"},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("unexpected changes:\n got %+v\nwant %+v", changes, expected)
+	}
+
+	if newimages["1.30"] == nil || !newimages["1.30"].Retired() || newimages["1.30"].Status() != drivercore.ImageStatusDownloaded {
+		t.Errorf("removed downloaded image was not retired")
+	}
+	if newimages["1.31"].Status() != drivercore.ImageStatusDownloaded {
+		t.Errorf("image with new URL but same checksum lost its downloaded status")
+	}
+	if newimages["1.32"].Status() != drivercore.ImageStatusNotDownloaded {
+		t.Errorf("image with new checksum kept its downloaded status")
+	}
+	if newimages["mine"] == nil || !newimages["mine"].Local() {
+		t.Errorf("local image was not kept")
+	}
+	if _, ok := newimages["1.29"]; ok {
+		t.Errorf("removed image that was not downloaded was kept")
+	}
+}
+
+func TestMergeImageStateFailedSource(t *testing.T) {
+	oldimages := map[string]*Image{
+		"1.30": {imageK8sVersion: "1.30", imageChecksum: "a", imageSource: "mirror", imageStatus: drivercore.ImageStatusDownloaded},
+		"1.29": {imageK8sVersion: "1.29", imageChecksum: "b", imageSource: "mirror", imageStatus: drivercore.ImageStatusNotDownloaded},
+		"1.31": {imageK8sVersion: "1.31", imageChecksum: "c", imageSource: "primary", imageStatus: drivercore.ImageStatusDownloaded},
+		"1.28": {imageK8sVersion: "1.28", imageChecksum: "d", imageSource: "primary", imageStatus: drivercore.ImageStatusNotDownloaded},
+	}
+	newimages := map[string]*Image{
+		"1.31": {imageK8sVersion: "1.31", imageChecksum: "c", imageSource: "primary", imageStatus: drivercore.ImageStatusNotDownloaded},
+	}
+
+	changes := mergeimagestate(oldimages, newimages, map[string]bool{"mirror": true})
+
+	expected := &ImageListChanges{Removed: []string{"1.28"}}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("unexpected changes:\n got %+v\nwant %+v", changes, expected)
+	}
+
+	for _, key := range []string{"1.30", "1.29"} {
+		image, ok := newimages[key]
+		if !ok || image != oldimages[key] || image.Retired() {
+			t.Errorf("image %s from failed source was not kept unchanged", key)
+		}
+	}
+	if newimages["1.30"].Status() != drivercore.ImageStatusDownloaded {
+		t.Errorf("image from failed source lost its downloaded status")
+	}
+}
+
+func TestCheckLocalImageTag(t *testing.T) {
+	images := map[string]*Image{
+		"1.31.2":  {imageK8sVersion: "1.31.2", imageSource: "kuttiproject"},
+		"mytag":   {imageK8sVersion: "mytag", imageLocal: true},
+		"1.30.0b": {imageK8sVersion: "1.30.0b", imageLocal: true},
+	}
+
+	tests := []struct {
+		tag   string
+		valid bool
+	}{
+		{"newtag", true},
+		{"my-tag_1.2+build", true},
+		{"mytag", true},
+		{"1.30.0b", true},
+		{"1.31.2", false},
+		{"", false},
+		{"-tag", false},
+		{".tag", false},
+		{"my tag", false},
+		{"my/tag", false},
+		{"../tag", false},
+	}
+
+	for _, test := range tests {
+		err := checklocalimagetag(test.tag, images)
+		if (err == nil) != test.valid {
+			t.Errorf("checklocalimagetag(%q) error %v; want valid %v", test.tag, err, test.valid)
+		}
+	}
+}
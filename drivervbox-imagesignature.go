@@ -0,0 +1,109 @@
+package drivervbox
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/kuttiproject/kuttilog"
+	"github.com/kuttiproject/workspace"
+)
+
+// projectImagesPublicKey is the base64-encoded ed25519 public key with which
+// the project signs its image lists. It is set when the driver is built,
+// with:
+//   -ldflags "-X github.com/kuttiproject/driver-vbox.projectImagesPublicKey=<key>"
+var projectImagesPublicKey string
+
+// ImagesPublicKeys contains base64-encoded ed25519 public keys. The image
+// list downloaded from each image source must carry a detached signature
+// made by one of these keys. The signature is downloaded from the location
+// of the image list with a ".sig" suffix, and contains the base64-encoded
+// signature of the exact contents of the list.
+// By default, it contains the project's public key. If it is empty, image
+// lists cannot be verified, and updating them fails.
+var ImagesPublicKeys = defaultimagespublickeys()
+
+// SkipImageListVerification disables signature verification of the image
+// list. Images are then validated only by the checksums in the list itself,
+// which offers no protection against a compromised or redirected source.
+var SkipImageListVerification = false
+
+func defaultimagespublickeys() []string {
+	if projectImagesPublicKey == "" {
+		return []string{}
+	}
+
+	return []string{projectImagesPublicKey}
+}
+
+const imagesSignatureSuffix = ".sig"
+
+// verifysourceimagelist verifies an image list downloaded from the specified
+// location into listpath, unless verification is skipped. The signature is
+// downloaded only if there are keys to check it against. A missing or
+// invalid signature is an error.
+func verifysourceimagelist(listlocation string, listpath string) error {
+	if SkipImageListVerification {
+		kuttilog.Println(kuttilog.Info, "Warning: image list signature verification skipped.")
+		return nil
+	}
+
+	if len(ImagesPublicKeys) == 0 {
+		return errors.New("no public keys configured for verifying the image list. Set ImagesPublicKeys, or SkipImageListVerification to rely on checksums only")
+	}
+
+	signaturepath := listpath + imagesSignatureSuffix
+	signaturelocation := listlocation + imagesSignatureSuffix
+
+	kuttilog.Printf(kuttilog.Debug, "Fetching signature from %v into %v.", signaturelocation, signaturepath)
+	err := fetchsourcefile(signaturelocation, signaturepath)
+	if err != nil {
+		return fmt.Errorf("could not fetch image list signature: %v", err)
+	}
+	defer workspace.RemoveFile(signaturepath)
+
+	return verifyimagelist(listpath, signaturepath)
+}
+
+// verifyimagelist checks the detached signature of an image list against
+// ImagesPublicKeys.
+func verifyimagelist(listpath string, signaturepath string) error {
+	listdata, err := os.ReadFile(listpath)
+	if err != nil {
+		return err
+	}
+
+	signaturedata, err := os.ReadFile(signaturepath)
+	if err != nil {
+		return err
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signaturedata)))
+	if err != nil || len(signature) != ed25519.SignatureSize {
+		return errors.New("image list signature is malformed")
+	}
+
+	validkeys := 0
+	for index, encodedkey := range ImagesPublicKeys {
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encodedkey))
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			kuttilog.Printf(kuttilog.Info, "Public key %d in ImagesPublicKeys is malformed. Skipping.", index)
+			continue
+		}
+		validkeys++
+
+		if ed25519.Verify(ed25519.PublicKey(key), listdata, signature) {
+			return nil
+		}
+	}
+
+	if validkeys == 0 {
+		return errors.New("no valid public keys configured for verifying the image list")
+	}
+
+	return errors.New("image list signature does not match any configured public key")
+}
@@ -0,0 +1,117 @@
+package drivervbox
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVerifyImageList(t *testing.T) {
+	publickey, privatekey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherkey, _ := ed25519.GenerateKey(nil)
+
+	savedkeys := ImagesPublicKeys
+	defer func() { ImagesPublicKeys = savedkeys }()
+	ImagesPublicKeys = []string{base64.StdEncoding.EncodeToString(publickey)}
+
+	dir := t.TempDir()
+	listpath := filepath.Join(dir, "images.json")
+	signaturepath := listpath + imagesSignatureSuffix
+	list := []byte(`{"1.32":{"ImageK8sVersion":"1.32","ImageChecksum":"abc"}}`)
+
+	writesignature := func(key ed25519.PrivateKey, data []byte) {
+		signature := base64.StdEncoding.EncodeToString(ed25519.Sign(key, data))
+		if err := os.WriteFile(signaturepath, []byte(signature+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := os.WriteFile(listpath, list, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	writesignature(privatekey, list)
+	if err := verifyimagelist(listpath, signaturepath); err != nil {
+		t.Errorf("valid signature rejected: %v", err)
+	}
+
+	writesignature(otherkey, list)
+	if err := verifyimagelist(listpath, signaturepath); err == nil {
+		t.Errorf("signature by unknown key accepted")
+	}
+
+	writesignature(privatekey, append(list, ' '))
+	if err := verifyimagelist(listpath, signaturepath); err == nil {
+		t.Errorf("signature of different content accepted")
+	}
+
+	// A malformed key must not block valid keys after it
+	ImagesPublicKeys = []string{"not a key", base64.StdEncoding.EncodeToString(publickey)}
+	writesignature(privatekey, list)
+	if err := verifyimagelist(listpath, signaturepath); err != nil {
+		t.Errorf("valid signature rejected after malformed key: %v", err)
+	}
+
+	ImagesPublicKeys = []string{"not a key"}
+	if err := verifyimagelist(listpath, signaturepath); err == nil {
+		t.Errorf("signature accepted with no valid keys")
+	}
+}
+
+func TestVerifySourceImageList(t *testing.T) {
+	publickey, privatekey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	savedkeys, savedskip := ImagesPublicKeys, SkipImageListVerification
+	defer func() { ImagesPublicKeys, SkipImageListVerification = savedkeys, savedskip }()
+
+	sourcedir := t.TempDir()
+	listlocation := filepath.Join(sourcedir, "images.json")
+	list := []byte(`{"1.32":{"ImageK8sVersion":"1.32","ImageChecksum":"abc"}}`)
+	if err := os.WriteFile(listlocation, list, 0644); err != nil {
+		t.Fatal(err)
+	}
+	listpath := filepath.Join(t.TempDir(), "vboximagesnewlist-0.json")
+	if err := os.WriteFile(listpath, list, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// An unsigned list is rejected with the default settings
+	ImagesPublicKeys, SkipImageListVerification = defaultimagespublickeys(), false
+	if err := verifysourceimagelist(listlocation, listpath); err == nil {
+		t.Errorf("unsigned image list accepted with default settings")
+	}
+
+	ImagesPublicKeys = []string{base64.StdEncoding.EncodeToString(publickey)}
+	if err := verifysourceimagelist(listlocation, listpath); err == nil {
+		t.Errorf("unsigned image list accepted")
+	}
+
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(privatekey, list))
+	if err := os.WriteFile(listlocation+imagesSignatureSuffix, []byte(signature), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifysourceimagelist(listlocation, listpath); err != nil {
+		t.Errorf("signed image list rejected: %v", err)
+	}
+
+	// Without keys, even a signed list cannot be verified
+	ImagesPublicKeys = []string{}
+	if err := verifysourceimagelist(listlocation, listpath); err == nil {
+		t.Errorf("image list accepted with no keys configured")
+	}
+
+	// Only an explicit opt-out skips verification
+	SkipImageListVerification = true
+	os.Remove(listlocation + imagesSignatureSuffix)
+	if err := verifysourceimagelist(listlocation, listpath); err != nil {
+		t.Errorf("unsigned image list rejected with verification skipped: %v", err)
+	}
+}
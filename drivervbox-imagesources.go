@@ -0,0 +1,221 @@
+package drivervbox
+
+import (
+	"fmt"
+	"hash"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/kuttiproject/kuttilog"
+	"github.com/kuttiproject/workspace"
+)
+
+// ImageSources is an ordered list of locations of image lists. Each entry
+// can be an http(s) URL of an image list, a file:// URL of an image list,
+// or a local directory containing a driver-vbox-images.json file.
+// When the image list is updated, the lists from all reachable sources are
+// merged. If an image version is present in more than one source, the entry
+// from the earliest source is used, and the others are kept as mirrors to
+// fall back on when downloading.
+// If ImageSources is empty, ImagesSourceURL is the only source.
+var ImageSources = []string{}
+
+func imagesources() []string {
+	if len(ImageSources) == 0 {
+		return []string{ImagesSourceURL}
+	}
+	return ImageSources
+}
+
+// localsourcepath returns the local file system path for a source or
+// image location, and true, if it is not an http(s) URL.
+func localsourcepath(location string) (string, bool) {
+	u, err := url.Parse(location)
+	if err == nil {
+		switch strings.ToLower(u.Scheme) {
+		case "http", "https":
+			return "", false
+		case "file":
+			p := u.Path
+			// file:///C:/dir on Windows
+			if len(p) > 2 && p[0] == '/' && p[2] == ':' {
+				p = p[1:]
+			}
+			return filepath.FromSlash(p), true
+		}
+	}
+
+	return location, true
+}
+
+// sourcelistlocation returns the location of the image list for a source.
+// For a local directory, this is the driver-vbox-images.json file in it.
+func sourcelistlocation(source string) string {
+	localpath, islocal := localsourcepath(source)
+	if !islocal {
+		return source
+	}
+
+	info, err := os.Stat(localpath)
+	if err == nil && info.IsDir() {
+		return filepath.Join(localpath, imagesConfigFile)
+	}
+
+	return localpath
+}
+
+// resolveimagelocation resolves an image location, which may be relative,
+// against the location of the image list it came from.
+func resolveimagelocation(listlocation string, imagelocation string) string {
+	if imagelocation == "" {
+		return imagelocation
+	}
+
+	if _, islocal := localsourcepath(listlocation); islocal {
+		if _, imageislocal := localsourcepath(imagelocation); !imageislocal || filepath.IsAbs(imagelocation) {
+			return imagelocation
+		}
+		if strings.HasPrefix(strings.ToLower(imagelocation), "file:") {
+			return imagelocation
+		}
+		return filepath.Join(filepath.Dir(listlocation), imagelocation)
+	}
+
+	base, err := url.Parse(listlocation)
+	if err != nil {
+		return imagelocation
+	}
+	ref, err := url.Parse(imagelocation)
+	if err != nil {
+		return imagelocation
+	}
+
+	return base.ResolveReference(ref).String()
+}
+
+// fetchsourcefile fetches a file from an http(s) URL or a local path
+// into the specified destination.
+func fetchsourcefile(location string, destpath string) error {
+	localpath, islocal := localsourcepath(location)
+	if !islocal {
+		return workspace.DownloadFile(location, destpath)
+	}
+
+	return copylocalfile(localpath, destpath, nil, nil)
+}
+
+// copylocalfile copies a local file, reporting progress and feeding
+// a hasher in the same way as downloadfile.
+func copylocalfile(srcpath string, destpath string, progress func(int64, int64), hasher hash.Hash) error {
+	src, err := os.Open(srcpath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	dest, err := os.Create(destpath)
+	if err != nil {
+		return err
+	}
+
+	var reader io.Reader = src
+	if progress != nil {
+		reader = &progressreader{
+			reader:   src,
+			total:    info.Size(),
+			progress: progress,
+		}
+	}
+
+	var writer io.Writer = dest
+	if hasher != nil {
+		writer = io.MultiWriter(dest, hasher)
+	}
+
+	_, err = io.Copy(writer, reader)
+	closeerr := dest.Close()
+	if err != nil {
+		return err
+	}
+
+	return closeerr
+}
+
+// fetchsourceimagelist fetches, verifies and loads the image list from
+// a single source. The locations of images in the list are resolved
+// against the source.
+func fetchsourceimagelist(index int, source string) (map[string]*Image, error) {
+	confdir, err := vboxConfigDir()
+	if err != nil {
+		return nil, err
+	}
+
+	listlocation := sourcelistlocation(source)
+	tempfilename := fmt.Sprintf("vboximagesnewlist-%d.json", index)
+	tempfilepath := path.Join(confdir, tempfilename)
+
+	kuttilog.Printf(kuttilog.Debug, "Fetching from %v into %v.", listlocation, tempfilepath)
+	err = fetchsourcefile(listlocation, tempfilepath)
+	if err != nil {
+		return nil, err
+	}
+	defer workspace.RemoveFile(tempfilepath)
+
+	// Verify signature, unless explicitly skipped
+	err = verifysourceimagelist(listlocation, tempfilepath)
+	if err != nil {
+		return nil, fmt.Errorf("could not verify image list from %s: %v", source, err)
+	}
+
+	// Load into object
+	tempimagedata := &imageconfigdata{}
+	tempconfigmanager, err := workspace.NewFileConfigManager(tempfilename, tempimagedata)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tempconfigmanager.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, image := range tempimagedata.images {
+		image.imageSourceURL = resolveimagelocation(listlocation, image.imageSourceURL)
+		image.imageSource = source
+	}
+
+	return tempimagedata.images, nil
+}
+
+// mergeimagelists merges image lists from several sources, in order of
+// preference. The first entry for each version is kept, and the locations
+// of later entries with the same checksum become its mirrors.
+func mergeimagelists(lists []map[string]*Image) map[string]*Image {
+	result := map[string]*Image{}
+
+	for _, list := range lists {
+		for key, image := range list {
+			existing, ok := result[key]
+			if !ok {
+				result[key] = image
+				continue
+			}
+
+			if image.imageChecksum == existing.imageChecksum &&
+				image.imageSourceURL != existing.imageSourceURL {
+				existing.imageMirrorURLs = append(existing.imageMirrorURLs, image.imageSourceURL)
+			}
+		}
+	}
+
+	return result
+}
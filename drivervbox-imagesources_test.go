@@ -0,0 +1,47 @@
+package drivervbox
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveImageLocation(t *testing.T) {
+	tests := []struct {
+		list, image, expected string
+	}{
+		{"https://mirror.example/images/list.json", "kutti-1.32.ova", "https://mirror.example/images/kutti-1.32.ova"},
+		{"https://mirror.example/images/list.json", "https://other.example/kutti-1.32.ova", "https://other.example/kutti-1.32.ova"},
+		{filepath.Join("lab", "images", "list.json"), "kutti-1.32.ova", filepath.Join("lab", "images", "kutti-1.32.ova")},
+		{filepath.Join("lab", "images", "list.json"), "https://other.example/kutti-1.32.ova", "https://other.example/kutti-1.32.ova"},
+	}
+
+	for _, test := range tests {
+		result := resolveimagelocation(test.list, test.image)
+		if result != test.expected {
+			t.Errorf("resolving %s against %s: expected %s, got %s", test.image, test.list, test.expected, result)
+		}
+	}
+}
+
+func TestMergeImageLists(t *testing.T) {
+	official := map[string]*Image{
+		"1.32": {imageK8sVersion: "1.32", imageChecksum: "aaa", imageSourceURL: "https://official/1.32.ova", imageSource: "official"},
+	}
+	mirror := map[string]*Image{
+		"1.32": {imageK8sVersion: "1.32", imageChecksum: "aaa", imageSourceURL: "http://mirror/1.32.ova", imageSource: "mirror"},
+		"1.31": {imageK8sVersion: "1.31", imageChecksum: "bbb", imageSourceURL: "http://mirror/1.31.ova", imageSource: "mirror"},
+	}
+
+	merged := mergeimagelists([]map[string]*Image{official, mirror})
+
+	if len(merged) != 2 {
+		t.Fatalf("expected 2 images, got %d", len(merged))
+	}
+	if merged["1.32"].Source() != "official" || len(merged["1.32"].imageMirrorURLs) != 1 ||
+		merged["1.32"].imageMirrorURLs[0] != "http://mirror/1.32.ova" {
+		t.Errorf("unexpected merged entry for 1.32: %+v", merged["1.32"])
+	}
+	if merged["1.31"].Source() != "mirror" {
+		t.Errorf("unexpected source for 1.31: %s", merged["1.31"].Source())
+	}
+}
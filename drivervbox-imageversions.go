@@ -0,0 +1,147 @@
+package drivervbox
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Aliases accepted in place of a Kubernetes version.
+const (
+	// K8sVersionLatest resolves to the newest available version.
+	K8sVersionLatest = "latest"
+	// K8sVersionStable resolves to the newest version that is not deprecated.
+	K8sVersionStable = "stable"
+)
+
+// imageversion is a parsed Kubernetes version, in the format
+// [v]major.minor[.patch][-prerelease].
+type imageversion struct {
+	parts      []int
+	prerelease string
+	valid      bool
+}
+
+func parseimageversion(version string) imageversion {
+	version = strings.TrimPrefix(version, "v")
+	version, prerelease, _ := strings.Cut(version, "-")
+
+	fields := strings.Split(version, ".")
+	if len(fields) < 2 || len(fields) > 3 {
+		return imageversion{}
+	}
+
+	result := imageversion{
+		parts:      make([]int, 3),
+		prerelease: prerelease,
+		valid:      true,
+	}
+	for i, field := range fields {
+		number, err := strconv.Atoi(field)
+		if err != nil || number < 0 {
+			return imageversion{}
+		}
+		result.parts[i] = number
+	}
+
+	return result
+}
+
+// compareimageversions compares two versions by semantic versioning rules.
+// Versions that cannot be parsed, such as custom tags of local images,
+// sort after all valid versions, in alphabetical order.
+func compareimageversions(a string, b string) int {
+	va, vb := parseimageversion(a), parseimageversion(b)
+
+	switch {
+	case !va.valid && !vb.valid:
+		return strings.Compare(a, b)
+	case !va.valid:
+		return 1
+	case !vb.valid:
+		return -1
+	}
+
+	for i := range va.parts {
+		if va.parts[i] != vb.parts[i] {
+			if va.parts[i] < vb.parts[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	// A prerelease sorts before the release itself
+	switch {
+	case va.prerelease == vb.prerelease:
+		return strings.Compare(a, b)
+	case va.prerelease == "":
+		return 1
+	case vb.prerelease == "":
+		return -1
+	}
+
+	return strings.Compare(va.prerelease, vb.prerelease)
+}
+
+// sortedimagekeys returns the keys of an image map, sorted by version.
+func sortedimagekeys(images map[string]*Image) []string {
+	result := make([]string, 0, len(images))
+	for key := range images {
+		result = append(result, key)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return compareimageversions(result[i], result[j]) < 0
+	})
+
+	return result
+}
+
+// resolvek8sversion resolves a Kubernetes version or alias to the key of
+// an image. An exact match always wins. Otherwise:
+//   - "latest" resolves to the newest version
+//   - "stable" resolves to the newest version that is not deprecated
+//   - "major.minor" resolves to the newest "major.minor.patch" version
+// Local and retired images are only matched exactly.
+func resolvek8sversion(images map[string]*Image, alias string) (string, bool) {
+	if _, ok := images[alias]; ok {
+		return alias, true
+	}
+
+	var match func(key string, image *Image) bool
+	switch alias {
+	case K8sVersionLatest:
+		match = func(key string, image *Image) bool {
+			return true
+		}
+	case K8sVersionStable:
+		match = func(key string, image *Image) bool {
+			return !image.imageDeprecated
+		}
+	default:
+		requested := parseimageversion(alias)
+		if !requested.valid || strings.Count(strings.TrimPrefix(alias, "v"), ".") != 1 {
+			return "", false
+		}
+		prefix := strings.TrimPrefix(alias, "v") + "."
+		match = func(key string, image *Image) bool {
+			return strings.HasPrefix(strings.TrimPrefix(key, "v"), prefix)
+		}
+	}
+
+	keys := sortedimagekeys(images)
+	for i := len(keys) - 1; i >= 0; i-- {
+		key := keys[i]
+		image := images[key]
+		if image.imageLocal || image.imageRetired || !parseimageversion(key).valid {
+			continue
+		}
+
+		if match(key, image) {
+			return key, true
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,58 @@
+package drivervbox
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSortedImageKeys(t *testing.T) {
+	images := map[string]*Image{
+		"1.9":          {},
+		"1.31.10":      {},
+		"1.31.2":       {},
+		"1.32":         {},
+		"1.32.0-rc.1":  {},
+		"custom-build": {},
+		"1.30":         {},
+	}
+
+	expected := []string{"1.9", "1.30", "1.31.2", "1.31.10", "1.32.0-rc.1", "1.32", "custom-build"}
+	if keys := sortedimagekeys(images); !reflect.DeepEqual(keys, expected) {
+		t.Errorf("unexpected order:\n got %v\nwant %v", keys, expected)
+	}
+}
+
+func TestResolveK8sVersion(t *testing.T) {
+	images := map[string]*Image{
+		"1.30.4":  {},
+		"1.31.2":  {},
+		"1.31.10": {},
+		"1.32.1":  {imageDeprecated: true},
+		"1.33.0":  {imageRetired: true},
+		"2.0":     {imageLocal: true},
+	}
+
+	tests := []struct {
+		alias    string
+		expected string
+		ok       bool
+	}{
+		{"1.30.4", "1.30.4", true},
+		{"1.33.0", "1.33.0", true},
+		{"latest", "1.32.1", true},
+		{"stable", "1.31.10", true},
+		{"1.31", "1.31.10", true},
+		{"v1.30", "1.30.4", true},
+		{"1.33", "", false},
+		{"1.29", "", false},
+		{"2", "", false},
+		{"nonsense", "", false},
+	}
+
+	for _, test := range tests {
+		result, ok := resolvek8sversion(images, test.alias)
+		if result != test.expected || ok != test.ok {
+			t.Errorf("resolvek8sversion(%q) = %q, %v; want %q, %v", test.alias, result, ok, test.expected, test.ok)
+		}
+	}
+}
@@ -0,0 +1,318 @@
+package drivervbox
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ovfenvelope is the subset of an OVF descriptor used by this driver.
+// An OVA file is a tar archive containing the descriptor, disk images,
+// and an optional manifest.
+type ovfenvelope struct {
+	XMLName        xml.Name           `xml:"Envelope"`
+	Disks          []ovfdisk          `xml:"DiskSection>Disk"`
+	VirtualSystems []ovfvirtualsystem `xml:"VirtualSystem"`
+	Collection     *struct {
+		VirtualSystems []ovfvirtualsystem `xml:"VirtualSystem"`
+	} `xml:"VirtualSystemCollection"`
+}
+
+type ovfvirtualsystem struct {
+	ID              string            `xml:"id,attr"`
+	Product         ovfproductsection `xml:"ProductSection"`
+	OperatingSystem ovfossection      `xml:"OperatingSystemSection"`
+	Hardware        []ovfhardwareitem `xml:"VirtualHardwareSection>Item"`
+}
+
+type ovfproductsection struct {
+	Product    string        `xml:"Product"`
+	Vendor     string        `xml:"Vendor"`
+	Version    string        `xml:"Version"`
+	Properties []ovfproperty `xml:"Property"`
+}
+
+type ovfproperty struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:"value,attr"`
+}
+
+// properties returns the product properties as a map of keys to values.
+func (ops *ovfproductsection) properties() map[string]string {
+	result := map[string]string{}
+	for _, property := range ops.Properties {
+		result[property.Key] = property.Value
+	}
+	return result
+}
+
+// ovfossection carries both the generic OVF operating system description
+// and the VirtualBox OS type id, which is more specific.
+type ovfossection struct {
+	Description string `xml:"Description"`
+	OSType      string `xml:"OSType"`
+}
+
+type ovfhardwareitem struct {
+	ResourceType    int    `xml:"ResourceType"`
+	VirtualQuantity int64  `xml:"VirtualQuantity"`
+	AllocationUnits string `xml:"AllocationUnits"`
+}
+
+type ovfdisk struct {
+	Capacity                string `xml:"capacity,attr"`
+	CapacityAllocationUnits string `xml:"capacityAllocationUnits,attr"`
+}
+
+// CIM resource types of virtual hardware items.
+const (
+	ovfResourceProcessor = 3
+	ovfResourceMemory    = 4
+)
+
+var ovfunitspattern, _ = regexp.Compile(`^byte\s*\*\s*2\^(\d+)$`)
+
+// ovfunitbytes returns the number of bytes in an OVF allocation unit, such
+// as "byte * 2^20" or "MegaBytes". If the unit is not specified, it is
+// taken to be bytes.
+func ovfunitbytes(units string) int64 {
+	units = strings.TrimSpace(units)
+
+	if matches := ovfunitspattern.FindStringSubmatch(units); matches != nil {
+		exponent, err := strconv.Atoi(matches[1])
+		if err == nil && exponent < 63 {
+			return 1 << exponent
+		}
+	}
+
+	switch strings.ToLower(units) {
+	case "kilobytes":
+		return 1 << 10
+	case "megabytes":
+		return 1 << 20
+	case "gigabytes":
+		return 1 << 30
+	}
+
+	return 1
+}
+
+// virtualsystems returns all virtual systems in the descriptor, whether it
+// describes a single VM or a collection.
+func (oe *ovfenvelope) virtualsystems() []ovfvirtualsystem {
+	if oe.Collection != nil {
+		return oe.Collection.VirtualSystems
+	}
+	return oe.VirtualSystems
+}
+
+// readovf reads the OVF descriptor from an OVA file. The OVF specification
+// requires the descriptor to be the first file in an OVA archive, so only
+// the first entry is read, however large the archive.
+func readovf(ovafile string) (*ovfenvelope, error) {
+	f, err := os.Open(ovafile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	tr := tar.NewReader(f)
+	header, err := tr.Next()
+	if err == io.EOF {
+		return nil, errors.New("no OVF descriptor found in " + ovafile)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasSuffix(strings.ToLower(header.Name), ".ovf") {
+		return nil, fmt.Errorf("first file %s in %s is not an OVF descriptor", header.Name, ovafile)
+	}
+
+	result := &ovfenvelope{}
+	err = xml.NewDecoder(tr).Decode(result)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+var (
+	ovfproductsectionendpattern, _ = regexp.Compile(`</(?:[A-Za-z0-9_]+:)?ProductSection>`)
+	ovfmanifestpattern, _          = regexp.Compile(`^(SHA1|SHA256|SHA512)\s*\((.+)\)\s*=\s*([0-9A-Fa-f]+)$`)
+)
+
+// insertovfproperties adds string product properties to the product
+// sections of an OVF descriptor. The nth set of properties goes into the
+// nth product section, which belongs to the nth virtual system.
+func insertovfproperties(descriptor []byte, properties [][]ovfproperty) ([]byte, error) {
+	if !bytes.Contains(descriptor, []byte(`xmlns:ovf="`)) {
+		return nil, errors.New("OVF descriptor does not declare the ovf namespace prefix")
+	}
+
+	locations := ovfproductsectionendpattern.FindAllIndex(descriptor, -1)
+	if len(locations) < len(properties) {
+		return nil, fmt.Errorf("OVF descriptor has %d product sections, expected %d", len(locations), len(properties))
+	}
+
+	result := &bytes.Buffer{}
+	last := 0
+	for index, systemproperties := range properties {
+		position := locations[index][0]
+		result.Write(descriptor[last:position])
+		for _, property := range systemproperties {
+			result.WriteString(`<Property ovf:key="`)
+			xml.EscapeText(result, []byte(property.Key))
+			result.WriteString(`" ovf:type="string" ovf:value="`)
+			xml.EscapeText(result, []byte(property.Value))
+			result.WriteString(`"/>`)
+		}
+		last = position
+	}
+	result.Write(descriptor[last:])
+
+	return result.Bytes(), nil
+}
+
+// updateovfmanifest updates the digest of a file in an OVA manifest,
+// keeping the digest algorithm. Lines for other files are unchanged.
+func updateovfmanifest(manifest []byte, filename string, data []byte) []byte {
+	lines := strings.Split(string(manifest), "\n")
+	for index, line := range lines {
+		match := ovfmanifestpattern.FindStringSubmatch(strings.TrimSpace(line))
+		if match == nil || match[2] != filename {
+			continue
+		}
+
+		var hasher hash.Hash
+		switch match[1] {
+		case "SHA1":
+			hasher = sha1.New()
+		case "SHA256":
+			hasher = sha256.New()
+		default:
+			hasher = sha512.New()
+		}
+		hasher.Write(data)
+
+		lines[index] = strings.Replace(line, match[3], hex.EncodeToString(hasher.Sum(nil)), 1)
+	}
+
+	return []byte(strings.Join(lines, "\n"))
+}
+
+// rewriteovf replaces the OVF descriptor in an OVA file with the result of
+// transform, and updates the manifest if there is one. As the OVF
+// specification requires, the descriptor must come first, and the manifest
+// before any other files.
+func rewriteovf(ovafile string, transform func([]byte) ([]byte, error)) error {
+	src, err := os.Open(ovafile)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tempfile := ovafile + ".rewrite"
+	dest, err := os.Create(tempfile)
+	if err != nil {
+		return err
+	}
+
+	err = rewriteovfarchive(src, dest, transform)
+	closeerr := dest.Close()
+	if err == nil {
+		err = closeerr
+	}
+	if err != nil {
+		os.Remove(tempfile)
+		return err
+	}
+
+	src.Close()
+	return os.Rename(tempfile, ovafile)
+}
+
+func rewriteovfarchive(r io.Reader, w io.Writer, transform func([]byte) ([]byte, error)) error {
+	tr := tar.NewReader(r)
+	tw := tar.NewWriter(w)
+
+	var descriptorname string
+	var descriptor []byte
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		name := strings.ToLower(header.Name)
+		switch {
+		case descriptor == nil && strings.HasSuffix(name, ".ovf"):
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				return err
+			}
+			descriptor, err = transform(data)
+			if err != nil {
+				return err
+			}
+			descriptorname = header.Name
+
+			err = writetarentry(tw, header, descriptor)
+			if err != nil {
+				return err
+			}
+		case descriptor != nil && strings.HasSuffix(name, ".mf"):
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				return err
+			}
+
+			err = writetarentry(tw, header, updateovfmanifest(data, descriptorname, descriptor))
+			if err != nil {
+				return err
+			}
+		default:
+			err = tw.WriteHeader(header)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(tw, tr)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if descriptor == nil {
+		return errors.New("no OVF descriptor found")
+	}
+
+	return tw.Close()
+}
+
+func writetarentry(tw *tar.Writer, header *tar.Header, data []byte) error {
+	header.Size = int64(len(data))
+	err := tw.WriteHeader(header)
+	if err != nil {
+		return err
+	}
+
+	_, err = tw.Write(data)
+	return err
+}
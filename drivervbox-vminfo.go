@@ -0,0 +1,166 @@
+package drivervbox
+
+import (
+	"bufio"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/kuttiproject/workspace"
+)
+
+var vmlistpattern, _ = regexp.Compile(`^"(.*)" \{(.*)\}$`)
+
+// parsemachinereadable parses the output of:
+//   VBoxManage showvminfo <machinename> --machinereadable
+// Each line is in the format key=value, where either the key or
+// the value may be double-quoted.
+func parsemachinereadable(output string) map[string]string {
+	result := map[string]string{}
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), "=")
+		if !found {
+			continue
+		}
+
+		result[unquote(key)] = unquote(value)
+	}
+
+	return result
+}
+
+func unquote(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// vminfo returns the machine-readable details of a VM.
+// It does this by running the command:
+//   VBoxManage showvminfo <machinename> --machinereadable
+func (vd *Driver) vminfo(qualifiedmachinename string) (map[string]string, error) {
+	output, err := workspace.RunWithResults(
+		vd.vboxmanagepath,
+		"showvminfo",
+		qualifiedmachinename,
+		"--machinereadable",
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not get details of machine %s: %v:%s", qualifiedmachinename, err, output)
+	}
+
+	return parsemachinereadable(output), nil
+}
+
+// machinenames returns the names of all VMs registered with VirtualBox.
+// It does this by running the command:
+//   VBoxManage list vms
+func (vd *Driver) machinenames() ([]string, error) {
+	output, err := workspace.RunWithResults(
+		vd.vboxmanagepath,
+		"list",
+		"vms",
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not list machines: %v:%s", err, output)
+	}
+
+	result := []string{}
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		match := vmlistpattern.FindStringSubmatch(strings.TrimSpace(scanner.Text()))
+		if match != nil {
+			result = append(result, match[1])
+		}
+	}
+
+	return result, nil
+}
+
+// clustermachinenames returns the qualified names of all VMs in
+// the VirtualBox group of a cluster. See clustermachineinfo.
+func (vd *Driver) clustermachinenames(clustername string) ([]string, error) {
+	machinenames, _, err := vd.clustermachineinfo(clustername)
+	return machinenames, err
+}
+
+// clustermachineinfo returns the qualified names of all VMs in the
+// VirtualBox group of a cluster, and their machine-readable details keyed
+// by name. The details are returned so that callers need not fetch them
+// again.
+// It does this by running the command:
+//   VBoxManage list vms
+// and then, for every VM whose name starts with "<clustername>-":
+//   VBoxManage showvminfo <machinename> --machinereadable
+func (vd *Driver) clustermachineinfo(clustername string) ([]string, map[string]map[string]string, error) {
+	machinenames, err := vd.machinenames()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	prefix := vd.QualifiedMachineName("", clustername)
+	names := []string{}
+	infos := map[string]map[string]string{}
+
+	for _, machinename := range machinenames {
+		if !strings.HasPrefix(machinename, prefix) {
+			continue
+		}
+
+		// Machine names may clash across clusters whose names share
+		// a prefix, so the group is the final arbiter.
+		info, err := vd.vminfo(machinename)
+		if err != nil {
+			continue
+		}
+
+		if invmgroup(info, clustername) {
+			names = append(names, machinename)
+			infos[machinename] = info
+		}
+	}
+
+	return names, infos, nil
+}
+
+// invmgroup returns true if the machine-readable details of a VM show
+// that it belongs to the VirtualBox group of a cluster.
+func invmgroup(info map[string]string, clustername string) bool {
+	group := "/" + clustername
+	for _, vmgroup := range strings.Split(info["groups"], ",") {
+		if vmgroup == group {
+			return true
+		}
+	}
+
+	return false
+}
+
+// vmproperty returns the value of a guest property of a VM, and
+// whether it was set.
+// It does this by running the command:
+//   VBoxManage guestproperty get <machinename> <propertyname>
+func (vd *Driver) vmproperty(qualifiedmachinename string, propname string) (string, bool) {
+	output, err := workspace.RunWithResults(
+		vd.vboxmanagepath,
+		"guestproperty",
+		"get",
+		qualifiedmachinename,
+		propname,
+	)
+
+	// VBoxManage guestproperty gets the hardcoded value "No value set!"
+	// if the property value cannot be retrieved
+	if err != nil || output == "No value set!" || output == "No value set!\n" {
+		return "", false
+	}
+
+	// Output is in the format
+	// Value: <value>
+	// So, 7th rune onwards
+	return output[7:], true
+}
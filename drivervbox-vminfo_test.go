@@ -0,0 +1,48 @@
+package drivervbox
+
+import "testing"
+
+func TestParseMachineReadable(t *testing.T) {
+	info := parsemachinereadable(testvminfo)
+
+	tests := map[string]string{
+		"name":               "cluster-node1",
+		"groups":             "/cluster",
+		"memory":             "2048",
+		"SATA-0-0":           "/vms/cluster/cluster-node1/disk001.vmdk",
+		"SATA-ImageUUID-0-0": "9f8e7d6c-5b4a-3928-1706-f5e4d3c2b1a0",
+		"nat-network1":       "clusterkuttinet",
+		"SnapshotName-1-1":   "nested",
+	}
+	for key, expected := range tests {
+		if info[key] != expected {
+			t.Errorf("value of %s is %q; want %q", key, info[key], expected)
+		}
+	}
+
+	if _, ok := info["nic9"]; ok {
+		t.Errorf("unexpected key nic9")
+	}
+}
+
+func TestInVMGroup(t *testing.T) {
+	tests := []struct {
+		groups      string
+		clustername string
+		expected    bool
+	}{
+		{"/cluster", "cluster", true},
+		{"/other,/cluster", "cluster", true},
+		{"/cluster", "clust", false},
+		{"/cluster/sub", "cluster", false},
+		{"/", "cluster", false},
+		{"", "cluster", false},
+	}
+
+	for _, test := range tests {
+		info := map[string]string{"groups": test.groups}
+		if result := invmgroup(info, test.clustername); result != test.expected {
+			t.Errorf("invmgroup(%q, %q) = %v; want %v", test.groups, test.clustername, result, test.expected)
+		}
+	}
+}
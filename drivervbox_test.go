@@ -2,6 +2,8 @@ package drivervbox_test
 
 import (
 	"context"
+	"crypto/ed25519"
+	"encoding/base64"
 	"fmt"
 	"net/http"
 	"os"
@@ -42,17 +44,32 @@ func TestDriverVBox(t *testing.T) {
 	server := http.Server{Addr: "localhost:8181", Handler: serverMux}
 	defer server.Shutdown(context.Background())
 
+	// The image list is signed with a key generated for the test
+	publickey, privatekey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	drivervbox.ImagesPublicKeys = []string{base64.StdEncoding.EncodeToString(publickey)}
+
+	imagelist := fmt.Sprintf(
+		`{"%v":{"ImageK8sVersion":"%v","ImageChecksum":"%v","ImageStatus":"NotDownloaded", "ImageSourceURL":"http://localhost:8181/kutti-%v.ova"}}`,
+		TESTK8SVERSION,
+		TESTK8SVERSION,
+		TESTK8SCHECKSUM,
+		TESTK8SVERSION,
+	)
+
 	serverMux.HandleFunc(
 		"/images.json",
 		func(w http.ResponseWriter, r *http.Request) {
-			fmt.Fprintf(
-				w,
-				`{"%v":{"ImageK8sVersion":"%v","ImageChecksum":"%v","ImageStatus":"NotDownloaded", "ImageSourceURL":"http://localhost:8181/kutti-%v.ova"}}`,
-				TESTK8SVERSION,
-				TESTK8SVERSION,
-				TESTK8SCHECKSUM,
-				TESTK8SVERSION,
-			)
+			fmt.Fprint(w, imagelist)
+		},
+	)
+
+	serverMux.HandleFunc(
+		"/images.json.sig",
+		func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, base64.StdEncoding.EncodeToString(ed25519.Sign(privatekey, []byte(imagelist))))
 		},
 	)
 
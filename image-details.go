@@ -0,0 +1,126 @@
+package drivervbox
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/kuttiproject/drivercore"
+)
+
+// ImageDetails describes the virtual machine contained in an image,
+// as recorded in the OVF descriptor inside the image file.
+type ImageDetails struct {
+	OSType string
+	// Architecture is the processor architecture of the image, using
+	// the same names as runtime.GOARCH. It is empty if not known.
+	Architecture   string
+	CPUs           int
+	MemoryMB       int
+	DiskCapacityMB int64
+	Product        string
+	Vendor         string
+	Version        string
+}
+
+// Details returns the details of the virtual machine contained in the
+// image. The image must have been downloaded.
+func (i *Image) Details() (*ImageDetails, error) {
+	if i.imageStatus != drivercore.ImageStatusDownloaded {
+		return nil, errors.New("image for K8s version " + i.imageK8sVersion + " has not been downloaded")
+	}
+
+	ovafile, err := imagepathfromk8sversion(i.imageK8sVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	return readimagedetails(ovafile)
+}
+
+func readimagedetails(ovafile string) (*ImageDetails, error) {
+	envelope, err := readovf(ovafile)
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %v", ovafile, err)
+	}
+
+	return parseimagedetails(envelope)
+}
+
+// parseimagedetails extracts image details from an OVF descriptor.
+// Machines are created from the first virtual system in an image.
+func parseimagedetails(envelope *ovfenvelope) (*ImageDetails, error) {
+	systems := envelope.virtualsystems()
+	if len(systems) == 0 {
+		return nil, errors.New("no virtual system found in OVF descriptor")
+	}
+	system := systems[0]
+
+	result := &ImageDetails{
+		OSType:  system.OperatingSystem.OSType,
+		Product: system.Product.Product,
+		Vendor:  system.Product.Vendor,
+		Version: system.Product.Version,
+	}
+	if result.OSType == "" {
+		result.OSType = system.OperatingSystem.Description
+	}
+	result.Architecture = ostypearchitecture(result.OSType)
+
+	for _, item := range system.Hardware {
+		switch item.ResourceType {
+		case ovfResourceProcessor:
+			result.CPUs = int(item.VirtualQuantity)
+		case ovfResourceMemory:
+			result.MemoryMB = int(item.VirtualQuantity * ovfunitbytes(item.AllocationUnits) / (1 << 20))
+		}
+	}
+
+	for _, disk := range envelope.Disks {
+		capacity, err := strconv.ParseInt(disk.Capacity, 10, 64)
+		if err != nil {
+			continue
+		}
+		result.DiskCapacityMB += capacity * ovfunitbytes(disk.CapacityAllocationUnits) / (1 << 20)
+	}
+
+	return result, nil
+}
+
+// ostypearchitecture returns the processor architecture of a VirtualBox
+// OS type id. Ids of 64-bit x86 types end in "_64", and ids of ARM types
+// in "_arm64".
+func ostypearchitecture(ostype string) string {
+	switch {
+	case strings.HasSuffix(ostype, "_arm64"):
+		return "arm64"
+	case strings.HasSuffix(ostype, "_64"):
+		return "amd64"
+	}
+
+	return ""
+}
+
+// checkimagearchitecture returns an error if an image file was built for
+// a processor architecture other than the host's. If the architecture
+// cannot be determined, the image is assumed to be compatible. Only the
+// OVF descriptor at the start of the file is read. See readovf.
+func checkimagearchitecture(ovafile string) error {
+	details, err := readimagedetails(ovafile)
+	if err != nil {
+		return err
+	}
+
+	if details.Architecture != "" && details.Architecture != runtime.GOARCH {
+		return fmt.Errorf(
+			"image %s is built for %s, but this host is %s",
+			ovafile,
+			details.Architecture,
+			runtime.GOARCH,
+		)
+	}
+
+	return nil
+}
@@ -0,0 +1,170 @@
+package drivervbox
+
+import (
+	"archive/tar"
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+const testovf = `<?xml version="1.0"?>
+<Envelope ovf:version="1.0" xml:lang="en-US" xmlns="http://schemas.dmtf.org/ovf/envelope/1" xmlns:ovf="http://schemas.dmtf.org/ovf/envelope/1" xmlns:rasd="http://schemas.dmtf.org/wbem/wscim/1/cim-schema/2/CIM_ResourceAllocationSettingData" xmlns:vbox="http://www.virtualbox.org/ovf/machine">
+  <References>
+    <File ovf:id="file1" ovf:href="kutti-disk001.vmdk"/>
+  </References>
+  <DiskSection>
+    <Info>List of the virtual disks used in the package</Info>
+    <Disk ovf:capacity="20" ovf:capacityAllocationUnits="byte * 2^30" ovf:diskId="vmdisk1" ovf:fileRef="file1"/>
+  </DiskSection>
+  <VirtualSystem ovf:id="kutti">
+    <Info>A virtual machine</Info>
+    <ProductSection>
+      <Info>Meta-information about the installed software</Info>
+      <Product>kutti</Product>
+      <Vendor>kuttiproject</Vendor>
+      <Version>1.31.2</Version>
+    </ProductSection>
+    <OperatingSystemSection ovf:id="96">
+      <Info>The kind of installed guest operating system</Info>
+      <Description>Debian_64</Description>
+      <vbox:OSType ovf:required="false">Debian12_arm64</vbox:OSType>
+    </OperatingSystemSection>
+    <VirtualHardwareSection>
+      <Info>Virtual hardware requirements for a virtual machine</Info>
+      <Item>
+        <rasd:ElementName>2 virtual CPU</rasd:ElementName>
+        <rasd:ResourceType>3</rasd:ResourceType>
+        <rasd:VirtualQuantity>2</rasd:VirtualQuantity>
+      </Item>
+      <Item>
+        <rasd:AllocationUnits>MegaBytes</rasd:AllocationUnits>
+        <rasd:ElementName>2048 MB of memory</rasd:ElementName>
+        <rasd:ResourceType>4</rasd:ResourceType>
+        <rasd:VirtualQuantity>2048</rasd:VirtualQuantity>
+      </Item>
+    </VirtualHardwareSection>
+  </VirtualSystem>
+</Envelope>`
+
+func TestParseImageDetails(t *testing.T) {
+	envelope := &ovfenvelope{}
+	err := xml.Unmarshal([]byte(testovf), envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	details, err := parseimagedetails(envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &ImageDetails{
+		OSType:         "Debian12_arm64",
+		Architecture:   "arm64",
+		CPUs:           2,
+		MemoryMB:       2048,
+		DiskCapacityMB: 20 * 1024,
+		Product:        "kutti",
+		Vendor:         "kuttiproject",
+		Version:        "1.31.2",
+	}
+	if !reflect.DeepEqual(details, expected) {
+		t.Errorf("unexpected details:\n got %+v\nwant %+v", details, expected)
+	}
+}
+
+func TestOVFUnitBytes(t *testing.T) {
+	tests := map[string]int64{
+		"":             1,
+		"byte":         1,
+		"byte * 2^20":  1 << 20,
+		"byte*2^30":    1 << 30,
+		"MegaBytes":    1 << 20,
+		"GigaBytes":    1 << 30,
+		"unknown unit": 1,
+	}
+
+	for units, expected := range tests {
+		if result := ovfunitbytes(units); result != expected {
+			t.Errorf("ovfunitbytes(%q) = %d; want %d", units, result, expected)
+		}
+	}
+}
+
+// writetestova writes an OVA file with the specified entries, in order,
+// followed by the specified trailing bytes.
+func writetestova(t *testing.T, entries [][2]string, trailer string) string {
+	ovafile := filepath.Join(t.TempDir(), "test.ova")
+	f, err := os.Create(ovafile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	tw := tar.NewWriter(f)
+	for _, entry := range entries {
+		err = tw.WriteHeader(&tar.Header{Name: entry[0], Mode: 0644, Size: int64(len(entry[1]))})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = tw.Write([]byte(entry[1]))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = tw.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Trailing bytes instead of the end-of-archive marker show that
+	// nothing after the descriptor is read
+	_, err = f.WriteString(trailer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ovafile
+}
+
+func TestReadOVFStopsAfterDescriptor(t *testing.T) {
+	ovafile := writetestova(t, [][2]string{
+		{"kutti.ovf", testovf},
+		{"kutti-disk001.vmdk", strings.Repeat("d", 2048)},
+	}, "not a tar header")
+
+	envelope, err := readovf(ovafile)
+	if err != nil {
+		t.Fatalf("readovf failed: %v", err)
+	}
+	if systems := envelope.virtualsystems(); len(systems) != 1 || systems[0].ID != "kutti" {
+		t.Errorf("unexpected virtual systems: %+v", systems)
+	}
+
+	err = checkimagearchitecture(ovafile)
+	if runtime.GOARCH == "arm64" && err != nil {
+		t.Errorf("arm64 image rejected on arm64 host: %v", err)
+	}
+	if runtime.GOARCH != "arm64" && err == nil {
+		t.Errorf("arm64 image accepted on %s host", runtime.GOARCH)
+	}
+}
+
+func TestReadOVFRequiresDescriptorFirst(t *testing.T) {
+	ovafile := writetestova(t, [][2]string{
+		{"kutti-disk001.vmdk", strings.Repeat("d", 2048)},
+		{"kutti.ovf", testovf},
+	}, "")
+
+	if _, err := readovf(ovafile); err == nil {
+		t.Errorf("OVA file with descriptor after disk accepted")
+	}
+
+	if _, err := readovf(writetestova(t, nil, "")); err == nil {
+		t.Errorf("empty OVA file accepted")
+	}
+}
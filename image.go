@@ -3,10 +3,11 @@ package drivervbox
 import (
 	"encoding/json"
 	"fmt"
+	"hash"
 	"path"
 
 	"github.com/kuttiproject/drivercore"
-	"github.com/kuttiproject/workspace"
+	"github.com/kuttiproject/kuttilog"
 )
 
 // vboximagedata is a data-only representation of the Image type,
@@ -17,6 +18,10 @@ type vboximagedata struct {
 	ImageSourceURL  string
 	ImageStatus     drivercore.ImageStatus
 	ImageDeprecated bool
+	ImageSource     string   `json:",omitempty"`
+	ImageMirrorURLs []string `json:",omitempty"`
+	ImageLocal      bool     `json:",omitempty"`
+	ImageRetired    bool     `json:",omitempty"`
 }
 
 // Image implements the drivercore.Image interface for VirtualBox.
@@ -26,6 +31,10 @@ type Image struct {
 	imageSourceURL  string
 	imageStatus     drivercore.ImageStatus
 	imageDeprecated bool
+	imageSource     string
+	imageMirrorURLs []string
+	imageLocal      bool
+	imageRetired    bool
 }
 
 // K8sVersion returns the version of Kubernetes present in the image.
@@ -47,50 +56,73 @@ func (i *Image) Deprecated() bool {
 	return i.imageDeprecated
 }
 
+// Source returns the image source that the image's entry came from.
+// See ImageSources.
+func (i *Image) Source() string {
+	return i.imageSource
+}
+
+// Local returns true if the image was built locally, and registered
+// with RegisterLocalImage rather than obtained from an image source.
+func (i *Image) Local() bool {
+	return i.imageLocal
+}
+
+// Retired returns true if the image is no longer available from any
+// image source, but is kept because it was downloaded.
+func (i *Image) Retired() bool {
+	return i.imageRetired
+}
+
 func (i *Image) fetch(progress func(int64, int64)) error {
 	cachedir, err := vboxCacheDir()
 	if err != nil {
 		return err
 	}
 
+	// The file is downloaded next to its final location in the cache,
+	// so that it can be moved into place atomically.
 	tempfilename := fmt.Sprintf("kutti-k8s-%s.ovadownload", i.imageK8sVersion)
 	tempfilepath := path.Join(cachedir, tempfilename)
 
-	// Download file
-	if progress != nil {
-		err = workspace.DownloadFileWithProgress(i.imageSourceURL, tempfilepath, progress)
-	} else {
-		err = workspace.DownloadFile(i.imageSourceURL, tempfilepath)
+	// Download file, resuming an earlier partial download if possible,
+	// and computing the checksum on the way.
+	// If the download fails, the partial file is kept for next time.
+	// If it cannot be downloaded from its source, mirrors are tried
+	// in turn.
+	var hasher hash.Hash
+	locations := append([]string{i.imageSourceURL}, i.imageMirrorURLs...)
+	for _, location := range locations {
+		hasher = newchecksumhasher()
+		if localpath, islocal := localsourcepath(location); islocal {
+			err = copylocalfile(localpath, tempfilepath, progress, hasher)
+		} else {
+			err = downloadfile(location, tempfilepath, progress, hasher)
+		}
+		if err == nil {
+			break
+		}
+
+		kuttilog.Printf(kuttilog.Info, "Could not download image from %v: %v", location, err)
 	}
 	if err != nil {
 		return err
 	}
-	defer workspace.RemoveFile(tempfilepath)
 
 	// Add
-	return i.FromFile(tempfilepath)
+	err = addfromdownload(i.imageK8sVersion, tempfilepath, i.imageChecksum, checksumstring(hasher))
+	if err != nil {
+		return err
+	}
+
+	i.imageStatus = drivercore.ImageStatusDownloaded
+	return imageconfigmanager.Save()
 }
 
 // Fetch downloads the image from its source URL.
+// An earlier interrupted download is resumed if possible.
 func (i *Image) Fetch() error {
 	return i.fetch(nil)
-	// cachedir, err := vboxCacheDir()
-	// if err != nil {
-	// 	return err
-	// }
-
-	// tempfilename := fmt.Sprintf("kutti-k8s-%s.ovadownload", i.imageK8sVersion)
-	// tempfilepath := path.Join(cachedir, tempfilename)
-
-	// // Download file
-	// err = workspace.DownloadFile(i.imageSourceURL, tempfilepath)
-	// if err != nil {
-	// 	return err
-	// }
-	// defer workspace.RemoveFile(tempfilepath)
-
-	// // Add
-	// return i.FromFile(tempfilepath)
 }
 
 // FetchWithProgress downloads the image from the driver repository into the
@@ -112,18 +144,54 @@ func (i *Image) FromFile(filepath string) error {
 }
 
 // PurgeLocal removes the local cached copy of an image.
+// If any Machines were created from the image, the copy is not removed,
+// and an error listing them is returned. Use ForcePurgeLocal to remove
+// it anyway. If VirtualBox is not available, the image is assumed to
+// be unused.
 func (i *Image) PurgeLocal() error {
-	if i.imageStatus == drivercore.ImageStatusDownloaded {
-		err := removefile(i.K8sVersion())
-		if err == nil {
-			i.imageStatus = drivercore.ImageStatusNotDownloaded
+	if i.imageStatus != drivercore.ImageStatusDownloaded {
+		return nil
+	}
 
-			return imageconfigmanager.Save()
-		}
+	imagemachines, err := machinesbyimage()
+	if err != nil {
+		return fmt.Errorf("could not check if image for K8s version %s is in use: %v", i.imageK8sVersion, err)
+	}
+
+	err = checkimagenotinuse(i.imageK8sVersion, imagemachines)
+	if err != nil {
 		return err
 	}
 
-	return nil
+	return i.purgelocal()
+}
+
+// ForcePurgeLocal removes the local cached copy of an image, even if
+// Machines were created from it. A warning is logged for each such Machine.
+func (i *Image) ForcePurgeLocal() error {
+	if i.imageStatus != drivercore.ImageStatusDownloaded {
+		return nil
+	}
+
+	imagemachines, err := machinesbyimage()
+	if err != nil {
+		kuttilog.Printf(kuttilog.Info, "Warning: could not check if image for K8s version %s is in use: %v", i.imageK8sVersion, err)
+	}
+	for _, machinename := range imagemachines[i.imageK8sVersion] {
+		kuttilog.Printf(kuttilog.Minimal, "Warning: machine %s was created from image for K8s version %s.", machinename, i.imageK8sVersion)
+	}
+
+	return i.purgelocal()
+}
+
+func (i *Image) purgelocal() error {
+	err := removefile(i.K8sVersion())
+	if err != nil {
+		return err
+	}
+
+	i.imageStatus = drivercore.ImageStatusNotDownloaded
+	return imageconfigmanager.Save()
 }
 
 // MarshalJSON returns the JSON encoding of the image.
@@ -134,6 +202,10 @@ func (i *Image) MarshalJSON() ([]byte, error) {
 		ImageSourceURL:  i.imageSourceURL,
 		ImageStatus:     i.imageStatus,
 		ImageDeprecated: i.imageDeprecated,
+		ImageSource:     i.imageSource,
+		ImageMirrorURLs: i.imageMirrorURLs,
+		ImageLocal:      i.imageLocal,
+		ImageRetired:    i.imageRetired,
 	}
 
 	return json.Marshal(savedata)
@@ -154,6 +226,10 @@ func (i *Image) UnmarshalJSON(b []byte) error {
 	i.imageSourceURL = loaddata.ImageSourceURL
 	i.imageStatus = loaddata.ImageStatus
 	i.imageDeprecated = loaddata.ImageDeprecated
+	i.imageSource = loaddata.ImageSource
+	i.imageMirrorURLs = loaddata.ImageMirrorURLs
+	i.imageLocal = loaddata.ImageLocal
+	i.imageRetired = loaddata.ImageRetired
 
 	return nil
 }
@@ -1,7 +1,10 @@
 package drivervbox
 
 import (
+	"errors"
 	"fmt"
+	"net"
+	"regexp"
 
 	"github.com/kuttiproject/drivercore"
 	"github.com/kuttiproject/workspace"
@@ -19,17 +22,8 @@ var (
 // This requires Virtual Machine Additions to be running in the guest operating system.
 // The guest OS should be fully booted up.
 func (vh *Machine) runwithresults(execpath string, paramarray ...string) (string, error) {
-	params := []string{
-		"guestcontrol",
-		vh.qname(),
-		"--username",
-		vboxUsername,
-		"--password",
-		vboxPassword,
-		"run",
-		"--",
-		execpath,
-	}
+	params := vh.guestcontrolparams("run")
+	params = append(params, "--", execpath)
 	params = append(params, paramarray...)
 
 	output, err := workspace.RunWithResults(
@@ -40,15 +34,106 @@ func (vh *Machine) runwithresults(execpath string, paramarray ...string) (string
 	return output, err
 }
 
+// guestcontrolparams returns the VBoxManage parameters for running the
+// specified guestcontrol subcommand as the kutti user.
+func (vh *Machine) guestcontrolparams(subcommand string) []string {
+	return []string{
+		"guestcontrol",
+		vh.qname(),
+		"--username",
+		vboxUsername,
+		"--password",
+		vboxPassword,
+		subcommand,
+	}
+}
+
+// Predefined commands implemented by this driver, in addition to those
+// defined by drivercore. They start at a high offset so as not to clash
+// with commands that drivercore may define in future.
+const (
+	// SetTimezone sets the guest timezone. It takes one parameter,
+	// an IANA timezone name such as "Asia/Kolkata".
+	SetTimezone drivercore.PredefinedCommand = iota + 1000
+	// SetDNSServers sets the guest DNS servers. It takes one or more
+	// parameters, each an IP address.
+	SetDNSServers
+	// AddHostsEntry adds an entry to the guest hosts file. It takes an
+	// IP address, followed by one or more host names.
+	AddHostsEntry
+	// RestartKubelet restarts the kubelet service. It takes no parameters.
+	RestartKubelet
+	// CollectLogs collects guest logs into a compressed archive, and
+	// copies it to the host. It takes one parameter, the host path of
+	// the archive.
+	CollectLogs
+)
+
 var vboxCommands = map[drivercore.PredefinedCommand]func(*Machine, ...string) error{
 	drivercore.RenameMachine: renamemachine,
+	SetTimezone:              settimezone,
+	SetDNSServers:            setdnsservers,
+	AddHostsEntry:            addhostsentry,
+	RestartKubelet:           restartkubelet,
+	CollectLogs:              collectlogs,
+}
+
+var (
+	hostnamepattern, _ = regexp.Compile(`^[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?(\.[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?)*$`)
+	timezonepattern, _ = regexp.Compile(`^[A-Za-z0-9_+-]+(/[A-Za-z0-9_+-]+)*$`)
+)
+
+// installscriptpath returns the guest path of a script in the
+// kutti-installscripts directory of the image.
+func installscriptpath(scriptname string) string {
+	return fmt.Sprintf("/home/%s/kutti-installscripts/%s", vboxUsername, scriptname)
+}
+
+// runinstallscript runs a script from the kutti-installscripts directory
+// of the image, using sudo.
+func (vh *Machine) runinstallscript(scriptname string, params ...string) error {
+	scriptparams := append([]string{installscriptpath(scriptname)}, params...)
+
+	output, err := vh.runwithresults(
+		"/usr/bin/sudo",
+		scriptparams...,
+	)
+	if err != nil {
+		return fmt.Errorf("%s failed on host '%s': %v:%s", scriptname, vh.name, err, output)
+	}
+
+	return nil
+}
+
+func checkparamcount(command string, params []string, min int, max int) error {
+	if len(params) < min || (max >= 0 && len(params) > max) {
+		switch {
+		case min == max:
+			return fmt.Errorf("%s needs exactly %d parameter(s), got %d", command, min, len(params))
+		case max < 0:
+			return fmt.Errorf("%s needs at least %d parameter(s), got %d", command, min, len(params))
+		default:
+			return fmt.Errorf("%s needs between %d and %d parameters, got %d", command, min, max, len(params))
+		}
+	}
+
+	return nil
 }
 
 func renamemachine(vh *Machine, params ...string) error {
+	err := checkparamcount("rename", params, 1, 1)
+	if err != nil {
+		return err
+	}
+
 	newname := params[0]
-	execname := fmt.Sprintf("/home/%s/kutti-installscripts/set-hostname.sh", vboxUsername)
+	if !hostnamepattern.MatchString(newname) {
+		return fmt.Errorf("invalid host name '%s'", newname)
+	}
+
+	execname := installscriptpath("set-hostname.sh")
 
-	_, err := vh.runwithresults(
+	_, err = vh.runwithresults(
 		"/usr/bin/sudo",
 		execname,
 		newname,
@@ -56,3 +141,82 @@ func renamemachine(vh *Machine, params ...string) error {
 
 	return err
 }
+
+func settimezone(vh *Machine, params ...string) error {
+	err := checkparamcount("set timezone", params, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	timezone := params[0]
+	if !timezonepattern.MatchString(timezone) {
+		return fmt.Errorf("invalid timezone '%s'", timezone)
+	}
+
+	return vh.runinstallscript("set-timezone.sh", timezone)
+}
+
+func setdnsservers(vh *Machine, params ...string) error {
+	err := checkparamcount("set DNS servers", params, 1, -1)
+	if err != nil {
+		return err
+	}
+
+	for _, server := range params {
+		if net.ParseIP(server) == nil {
+			return fmt.Errorf("invalid DNS server address '%s'", server)
+		}
+	}
+
+	return vh.runinstallscript("set-dns.sh", params...)
+}
+
+func addhostsentry(vh *Machine, params ...string) error {
+	err := checkparamcount("add hosts entry", params, 2, -1)
+	if err != nil {
+		return err
+	}
+
+	if net.ParseIP(params[0]) == nil {
+		return fmt.Errorf("invalid IP address '%s'", params[0])
+	}
+
+	for _, hostname := range params[1:] {
+		if !hostnamepattern.MatchString(hostname) {
+			return fmt.Errorf("invalid host name '%s'", hostname)
+		}
+	}
+
+	return vh.runinstallscript("add-hosts-entry.sh", params...)
+}
+
+func restartkubelet(vh *Machine, params ...string) error {
+	err := checkparamcount("restart kubelet", params, 0, 0)
+	if err != nil {
+		return err
+	}
+
+	return vh.runinstallscript("restart-kubelet.sh")
+}
+
+func collectlogs(vh *Machine, params ...string) error {
+	err := checkparamcount("collect logs", params, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	hostpath := params[0]
+	if hostpath == "" {
+		return errors.New("collect logs needs a non-empty host path")
+	}
+
+	// The script writes a compressed archive to the specified guest
+	// path, which is then copied to the host.
+	guestpath := fmt.Sprintf("/tmp/kutti-logs-%s.tar.gz", vh.name)
+	err = vh.runinstallscript("collect-logs.sh", guestpath)
+	if err != nil {
+		return err
+	}
+
+	return vh.CopyFrom(guestpath, hostpath, nil)
+}
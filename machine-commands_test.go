@@ -0,0 +1,85 @@
+package drivervbox
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckParamCount(t *testing.T) {
+	tests := []struct {
+		params  []string
+		min     int
+		max     int
+		message string
+	}{
+		{[]string{"a"}, 1, 1, ""},
+		{nil, 0, 0, ""},
+		{[]string{"a", "b", "c"}, 1, -1, ""},
+		{[]string{"a", "b"}, 1, 3, ""},
+		{nil, 1, 1, "exactly 1 parameter(s), got 0"},
+		{[]string{"a", "b"}, 1, 1, "exactly 1 parameter(s), got 2"},
+		{[]string{"a"}, 2, -1, "at least 2 parameter(s), got 1"},
+		{[]string{"a", "b", "c", "d"}, 1, 3, "between 1 and 3 parameters, got 4"},
+	}
+
+	for _, test := range tests {
+		err := checkparamcount("test", test.params, test.min, test.max)
+		if test.message == "" {
+			if err != nil {
+				t.Errorf("checkparamcount(%v, %d, %d) returned error %v", test.params, test.min, test.max, err)
+			}
+			continue
+		}
+
+		if err == nil || !strings.Contains(err.Error(), test.message) {
+			t.Errorf("checkparamcount(%v, %d, %d) error %v; want %q", test.params, test.min, test.max, err, test.message)
+		}
+	}
+}
+
+func TestInstallScriptPath(t *testing.T) {
+	expected := "/home/" + vboxUsername + "/kutti-installscripts/set-timezone.sh"
+	if result := installscriptpath("set-timezone.sh"); result != expected {
+		t.Errorf("installscriptpath returned %s; want %s", result, expected)
+	}
+}
+
+// Invalid parameters must be rejected before anything runs in the guest,
+// so these tests do not need VirtualBox.
+func TestPredefinedCommandValidation(t *testing.T) {
+	vh := &Machine{driver: &Driver{}, name: "node1", clustername: "zang"}
+
+	tests := []struct {
+		command string
+		params  []string
+	}{
+		{"rename", nil},
+		{"rename", []string{"-bad"}},
+		{"rename", []string{"node_1"}},
+		{"set timezone", []string{"Asia/Kolkata; reboot"}},
+		{"set timezone", []string{"Asia/Kolkata", "UTC"}},
+		{"set DNS servers", nil},
+		{"set DNS servers", []string{"8.8.8.8", "dns.example.com"}},
+		{"add hosts entry", []string{"10.0.0.1"}},
+		{"add hosts entry", []string{"not-an-ip", "host1"}},
+		{"add hosts entry", []string{"10.0.0.1", "bad host"}},
+		{"restart kubelet", []string{"now"}},
+		{"collect logs", []string{""}},
+		{"collect logs", nil},
+	}
+
+	commands := map[string]func(*Machine, ...string) error{
+		"rename":          renamemachine,
+		"set timezone":    settimezone,
+		"set DNS servers": setdnsservers,
+		"add hosts entry": addhostsentry,
+		"restart kubelet": restartkubelet,
+		"collect logs":    collectlogs,
+	}
+
+	for _, test := range tests {
+		if err := commands[test.command](vh, test.params...); err == nil {
+			t.Errorf("%s accepted invalid parameters %q", test.command, test.params)
+		}
+	}
+}
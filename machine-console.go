@@ -0,0 +1,134 @@
+package drivervbox
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kuttiproject/workspace"
+)
+
+// EnableSerialConsole controls whether NewMachine configures new machines
+// to write their first serial port to a log file in the machine's folder.
+// The guest operating system must be configured to use the serial port
+// (ttyS0) as a console for anything useful to appear there.
+var EnableSerialConsole = false
+
+const consoleLogFile = "console.log"
+
+// machinefolder returns the folder containing the Machine's settings file.
+// It does this by running the command:
+//   VBoxManage showvminfo <machinename> --machinereadable
+// and reading the CfgFile value.
+func (vh *Machine) machinefolder() (string, error) {
+	info, err := vh.driver.vminfo(vh.qname())
+	if err != nil {
+		return "", err
+	}
+
+	cfgfile := info["CfgFile"]
+	if cfgfile == "" {
+		return "", fmt.Errorf("could not find settings file of host '%s'", vh.name)
+	}
+
+	return filepath.Dir(cfgfile), nil
+}
+
+// enableserialconsole connects the first serial port of the Machine to a log
+// file in the machine's folder.
+// It does this by running the command:
+//   VBoxManage modifyvm <machinename> --uart1 0x3F8 4 --uart-mode1 file <logfilepath>
+func (vh *Machine) enableserialconsole() error {
+	folder, err := vh.machinefolder()
+	if err != nil {
+		return err
+	}
+
+	logfilepath := filepath.Join(folder, consoleLogFile)
+	output, err := workspace.RunWithResults(
+		vh.driver.vboxmanagepath,
+		"modifyvm",
+		vh.qname(),
+		"--uart1",
+		"0x3F8",
+		"4",
+		"--uart-mode1",
+		"file",
+		logfilepath,
+	)
+	if err != nil {
+		return fmt.Errorf("could not configure serial console for host '%s': %v:%s", vh.name, err, output)
+	}
+
+	return nil
+}
+
+// ConsoleLogPath returns the path of the serial console log file of the
+// Machine, or an error if the serial console is not configured.
+// It does this by running the command:
+//   VBoxManage showvminfo <machinename> --machinereadable
+// and reading the uartmode1 value.
+func (vh *Machine) ConsoleLogPath() (string, error) {
+	info, err := vh.driver.vminfo(vh.qname())
+	if err != nil {
+		return "", err
+	}
+
+	logfilepath, ok := consolelogpath(info)
+	if !ok {
+		return "", fmt.Errorf("serial console is not configured for host '%s'", vh.name)
+	}
+
+	return logfilepath, nil
+}
+
+// consolelogpath returns the log file path of the first serial port of
+// a VM from its machine-readable details, if the port writes to a file.
+func consolelogpath(info map[string]string) (string, bool) {
+	// The value is in the format file,<path>
+	mode, logfilepath, _ := strings.Cut(info["uartmode1"], ",")
+	if mode != "file" || logfilepath == "" {
+		return "", false
+	}
+
+	return logfilepath, true
+}
+
+// ConsoleLog returns the last maxlines lines of the serial console log of
+// the Machine. If maxlines is zero or less, the entire log is returned.
+// The log is only available if the Machine was created with
+// EnableSerialConsole set to true.
+func (vh *Machine) ConsoleLog(maxlines int) (string, error) {
+	logfilepath, err := vh.ConsoleLogPath()
+	if err != nil {
+		return "", err
+	}
+
+	content, err := os.ReadFile(logfilepath)
+	if errors.Is(err, os.ErrNotExist) {
+		// The file is only created when the Machine starts
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("could not read serial console log of host '%s': %v", vh.name, err)
+	}
+
+	return lastlines(string(content), maxlines), nil
+}
+
+// lastlines returns the last maxlines lines of a text, or all of it if
+// maxlines is zero or less.
+func lastlines(text string, maxlines int) string {
+	if maxlines <= 0 {
+		return text
+	}
+
+	lines := strings.SplitAfter(strings.TrimSuffix(text, "\n"), "\n")
+	if len(lines) > maxlines {
+		lines = lines[len(lines)-maxlines:]
+	}
+
+	return strings.Join(lines, "")
+}
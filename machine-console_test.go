@@ -0,0 +1,48 @@
+package drivervbox
+
+import "testing"
+
+func TestConsoleLogPath(t *testing.T) {
+	tests := []struct {
+		vminfo   string
+		expected string
+		ok       bool
+	}{
+		{`uart1="0x03f8,4"` + "\n" + `uartmode1="file,/vms/cluster/cluster-node1/console.log"`, "/vms/cluster/cluster-node1/console.log", true},
+		{`uart1="0x03f8,4"` + "\n" + `uartmode1="disconnected"`, "", false},
+		{`uart1="0x03f8,4"` + "\n" + `uartmode1="server,/tmp/node1.sock"`, "", false},
+		{`uart1="off"`, "", false},
+		{`uartmode1="file,"`, "", false},
+	}
+
+	for _, test := range tests {
+		result, ok := consolelogpath(parsemachinereadable(test.vminfo))
+		if result != test.expected || ok != test.ok {
+			t.Errorf("consolelogpath(%q) = %q, %v; want %q, %v", test.vminfo, result, ok, test.expected, test.ok)
+		}
+	}
+}
+
+func TestLastLines(t *testing.T) {
+	log := "line1\nline2\nline3\n"
+
+	tests := []struct {
+		text     string
+		maxlines int
+		expected string
+	}{
+		{log, 0, log},
+		{log, -1, log},
+		{log, 2, "line2\nline3"},
+		{log, 3, "line1\nline2\nline3"},
+		{log, 10, "line1\nline2\nline3"},
+		{"line1\nline2", 1, "line2"},
+		{"", 5, ""},
+	}
+
+	for _, test := range tests {
+		if result := lastlines(test.text, test.maxlines); result != test.expected {
+			t.Errorf("lastlines(%q, %d) = %q; want %q", test.text, test.maxlines, result, test.expected)
+		}
+	}
+}
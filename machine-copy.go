@@ -0,0 +1,375 @@
+package drivervbox
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kuttiproject/kuttilog"
+	"github.com/kuttiproject/workspace"
+)
+
+// CopyOptions control how files are copied by CopyTo and CopyFrom.
+// A nil *CopyOptions is the same as the zero value.
+type CopyOptions struct {
+	// Recursive allows the source to be a directory, in which case
+	// its entire contents are copied.
+	Recursive bool
+	// Progress, if not nil, is called periodically while files are
+	// copied, and after each file is copied, with the number of bytes
+	// copied so far and the total number of bytes.
+	Progress func(current int64, total int64)
+	// VerifyChecksum compares the SHA256 checksums of each source and
+	// destination file after copying.
+	VerifyChecksum bool
+}
+
+// copyProgressInterval is how often progress is checked while a single
+// file is being copied.
+const copyProgressInterval = time.Second
+
+// copyitem is a single file to be copied, with paths relative to the
+// source and destination roots.
+type copyitem struct {
+	relpath string
+	size    int64
+}
+
+// CopyTo copies a file or directory from the host into the Machine.
+// It does this by running the following command for each file:
+//   VBoxManage guestcontrol <machinename> --username <username> --password <password> copyto <hostpath> <guestpath>
+// Directories are created as needed by running the command:
+//   VBoxManage guestcontrol <machinename> --username <username> --password <password> mkdir --parents <guestdir>
+// If the source is a directory, options.Recursive must be true, and guestpath is
+// the directory which will receive its contents.
+// This requires Virtual Machine Additions to be running in the guest operating
+// system. The guest OS should be fully booted up.
+func (vh *Machine) CopyTo(hostpath string, guestpath string, options *CopyOptions) error {
+	if options == nil {
+		options = &CopyOptions{}
+	}
+
+	items, err := hostcopyitems(hostpath, options.Recursive)
+	if err != nil {
+		return err
+	}
+
+	total := copytotal(items)
+	var current int64
+	for _, item := range items {
+		src, dest := filepath.Join(hostpath, item.relpath), path.Join(guestpath, filepath.ToSlash(item.relpath))
+
+		params := vh.guestcontrolparams("mkdir")
+		params = append(params, "--parents", path.Dir(dest))
+		output, err := workspace.RunWithResults(vh.driver.vboxmanagepath, params...)
+		if err != nil {
+			return fmt.Errorf("could not create directory %s on host '%s': %v:%s", path.Dir(dest), vh.name, err, output)
+		}
+
+		kuttilog.Printf(kuttilog.Debug, "Copying %s to %s:%s...", src, vh.name, dest)
+		err = trackcopyprogress(
+			copyProgressInterval,
+			func() error {
+				params := vh.guestcontrolparams("copyto")
+				params = append(params, src, dest)
+				output, err := workspace.RunWithResults(vh.driver.vboxmanagepath, params...)
+				if err != nil {
+					return fmt.Errorf("could not copy %s to host '%s': %v:%s", src, vh.name, err, output)
+				}
+				return nil
+			},
+			func() int64 {
+				return vh.guestfilesize(dest)
+			},
+			itemprogress(options.Progress, current, item.size, total),
+		)
+		if err != nil {
+			return err
+		}
+
+		if options.VerifyChecksum {
+			err = vh.verifycopy(src, dest)
+			if err != nil {
+				return err
+			}
+		}
+
+		current += item.size
+		if options.Progress != nil {
+			options.Progress(current, total)
+		}
+	}
+
+	return nil
+}
+
+// CopyFrom copies a file or directory from the Machine to the host.
+// It does this by running the following command for each file:
+//   VBoxManage guestcontrol <machinename> --username <username> --password <password> copyfrom <guestpath> <hostpath>
+// The files to be copied are discovered by running find in the guest.
+// If the source is a directory, options.Recursive must be true, and hostpath is
+// the directory which will receive its contents.
+// This requires Virtual Machine Additions to be running in the guest operating
+// system. The guest OS should be fully booted up.
+func (vh *Machine) CopyFrom(guestpath string, hostpath string, options *CopyOptions) error {
+	if options == nil {
+		options = &CopyOptions{}
+	}
+
+	items, err := vh.guestcopyitems(guestpath, options.Recursive)
+	if err != nil {
+		return err
+	}
+
+	total := copytotal(items)
+	var current int64
+	for _, item := range items {
+		src, dest := path.Join(guestpath, item.relpath), filepath.Join(hostpath, filepath.FromSlash(item.relpath))
+
+		err = os.MkdirAll(filepath.Dir(dest), 0755)
+		if err != nil {
+			return err
+		}
+
+		kuttilog.Printf(kuttilog.Debug, "Copying %s:%s to %s...", vh.name, src, dest)
+		err = trackcopyprogress(
+			copyProgressInterval,
+			func() error {
+				params := vh.guestcontrolparams("copyfrom")
+				params = append(params, src, dest)
+				output, err := workspace.RunWithResults(vh.driver.vboxmanagepath, params...)
+				if err != nil {
+					return fmt.Errorf("could not copy %s from host '%s': %v:%s", src, vh.name, err, output)
+				}
+				return nil
+			},
+			func() int64 {
+				return hostfilesize(dest)
+			},
+			itemprogress(options.Progress, current, item.size, total),
+		)
+		if err != nil {
+			return err
+		}
+
+		if options.VerifyChecksum {
+			err = vh.verifycopy(dest, src)
+			if err != nil {
+				return err
+			}
+		}
+
+		current += item.size
+		if options.Progress != nil {
+			options.Progress(current, total)
+		}
+	}
+
+	return nil
+}
+
+// trackcopyprogress runs copyfile, and until it finishes, reports the number
+// of bytes copied so far, as returned by copied, at the specified interval.
+// If report is nil, progress is not tracked.
+func trackcopyprogress(interval time.Duration, copyfile func() error, copied func() int64, report func(int64)) error {
+	if report == nil {
+		return copyfile()
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- copyfile()
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case err := <-done:
+			return err
+		case <-ticker.C:
+			report(copied())
+		}
+	}
+}
+
+// itemprogress converts the progress of copying a single file into the
+// progress of the whole copy, for reporting via the Progress option.
+// It returns nil if progress is not required.
+func itemprogress(progress func(int64, int64), completed int64, itemsize int64, total int64) func(int64) {
+	if progress == nil {
+		return nil
+	}
+
+	return func(copied int64) {
+		if copied > itemsize {
+			copied = itemsize
+		}
+		if copied < 0 {
+			copied = 0
+		}
+		progress(completed+copied, total)
+	}
+}
+
+// hostfilesize returns the current size of a host file, or 0.
+func hostfilesize(hostpath string) int64 {
+	info, err := os.Stat(hostpath)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// guestfilesize returns the current size of a guest file, or 0.
+// It does this by running the following command in the guest:
+//   stat -c %s <guestpath>
+func (vh *Machine) guestfilesize(guestpath string) int64 {
+	result, err := vh.Exec(nil, "/usr/bin/stat", "-c", "%s", guestpath)
+	if err != nil || result.ExitCode != 0 {
+		return 0
+	}
+
+	size, err := strconv.ParseInt(strings.TrimSpace(result.Stdout), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return size
+}
+
+func copytotal(items []copyitem) int64 {
+	var total int64
+	for _, item := range items {
+		total += item.size
+	}
+	return total
+}
+
+// hostcopyitems lists the files to be copied from a host path.
+func hostcopyitems(hostpath string, recursive bool) ([]copyitem, error) {
+	info, err := os.Stat(hostpath)
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.IsDir() {
+		return []copyitem{{relpath: "", size: info.Size()}}, nil
+	}
+
+	if !recursive {
+		return nil, fmt.Errorf("%s is a directory. Recursive copy must be specified", hostpath)
+	}
+
+	result := []copyitem{}
+	err = filepath.WalkDir(hostpath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		relpath, err := filepath.Rel(hostpath, p)
+		if err != nil {
+			return err
+		}
+
+		result = append(result, copyitem{relpath: relpath, size: info.Size()})
+		return nil
+	})
+
+	return result, err
+}
+
+// guestcopyitems lists the files to be copied from a guest path.
+// It does this by running the following command in the guest:
+//   find <guestpath> -type f -printf "%s %P\n"
+func (vh *Machine) guestcopyitems(guestpath string, recursive bool) ([]copyitem, error) {
+	findparams := []string{guestpath}
+	if !recursive {
+		findparams = append(findparams, "-maxdepth", "0")
+	}
+	findparams = append(findparams, "-type", "f", "-printf", "%s %P\\n")
+
+	result, err := vh.Exec(nil, "/usr/bin/find", findparams...)
+	if err != nil {
+		return nil, err
+	}
+	if result.ExitCode != 0 {
+		return nil, fmt.Errorf("could not list %s on host '%s': %s", guestpath, vh.name, strings.TrimSpace(result.Stderr))
+	}
+
+	items := []copyitem{}
+	for _, line := range strings.Split(result.Stdout, "\n") {
+		sizestr, relpath, found := strings.Cut(line, " ")
+		if !found {
+			continue
+		}
+
+		size, err := strconv.ParseInt(sizestr, 10, 64)
+		if err != nil {
+			continue
+		}
+
+		items = append(items, copyitem{relpath: relpath, size: size})
+	}
+
+	if len(items) == 0 {
+		if !recursive {
+			return nil, fmt.Errorf("%s on host '%s' is not a file. Recursive copy must be specified for directories", guestpath, vh.name)
+		}
+		kuttilog.Printf(kuttilog.Info, "No files found under %s on host '%s'.", guestpath, vh.name)
+	}
+
+	return items, nil
+}
+
+// verifycopy compares the SHA256 checksum of a host file with that
+// of a guest file. The guest checksum is computed by running:
+//   sha256sum <guestpath>
+func (vh *Machine) verifycopy(hostpath string, guestpath string) error {
+	result, err := vh.Exec(nil, "/usr/bin/sha256sum", guestpath)
+	if err != nil {
+		return err
+	}
+	if result.ExitCode != 0 {
+		return fmt.Errorf("could not compute checksum of %s on host '%s': %s", guestpath, vh.name, strings.TrimSpace(result.Stderr))
+	}
+
+	err = comparecopychecksum(hostpath, result.Stdout)
+	if err != nil {
+		return fmt.Errorf("%v and %s on host '%s'", err, guestpath, vh.name)
+	}
+
+	return nil
+}
+
+// comparecopychecksum compares the checksum of a host file with the output
+// of sha256sum for the copy of the file.
+func comparecopychecksum(hostpath string, sha256sumoutput string) error {
+	hasher := newchecksumhasher()
+	err := hashfile(hostpath, hasher)
+	if err != nil {
+		return err
+	}
+	hostchecksum := checksumstring(hasher)
+
+	copychecksum, _, _ := strings.Cut(strings.TrimSpace(sha256sumoutput), " ")
+	if !strings.EqualFold(copychecksum, hostchecksum) {
+		kuttilog.Printf(kuttilog.Debug, "checksum for file %v failed.\nHost : %v\nGuest: %v\n", hostpath, hostchecksum, copychecksum)
+		return fmt.Errorf("checksum mismatch between %s", hostpath)
+	}
+
+	return nil
+}
@@ -0,0 +1,133 @@
+package drivervbox
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompareCopyChecksum(t *testing.T) {
+	hostpath := filepath.Join(t.TempDir(), "file.txt")
+	content := []byte("kubeconfig contents")
+	if err := os.WriteFile(hostpath, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(content)
+	checksum := hex.EncodeToString(sum[:])
+
+	if err := comparecopychecksum(hostpath, checksum+"  /home/kutti/file.txt\n"); err != nil {
+		t.Errorf("matching checksum rejected: %v", err)
+	}
+	if err := comparecopychecksum(hostpath, strings.ToUpper(checksum)+"  /home/kutti/file.txt\n"); err != nil {
+		t.Errorf("matching uppercase checksum rejected: %v", err)
+	}
+	if err := comparecopychecksum(hostpath, strings.Repeat("0", 64)+"  /home/kutti/file.txt\n"); err == nil {
+		t.Errorf("mismatched checksum accepted")
+	}
+	if err := comparecopychecksum(hostpath, ""); err == nil {
+		t.Errorf("empty sha256sum output accepted")
+	}
+	if err := comparecopychecksum(filepath.Join(t.TempDir(), "missing"), checksum); err == nil {
+		t.Errorf("missing host file accepted")
+	}
+}
+
+func TestTrackCopyProgress(t *testing.T) {
+	destpath := filepath.Join(t.TempDir(), "dest")
+	copyfile := func() error {
+		f, err := os.Create(destpath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		for i := 0; i < 5; i++ {
+			f.Write(make([]byte, 100))
+			time.Sleep(20 * time.Millisecond)
+		}
+		return nil
+	}
+
+	reports := []int64{}
+	progress := func(current int64, total int64) {
+		if total != 1500 {
+			t.Errorf("total %d; want 1500", total)
+		}
+		reports = append(reports, current)
+	}
+
+	err := trackcopyprogress(5*time.Millisecond, copyfile, func() int64 {
+		return hostfilesize(destpath)
+	}, itemprogress(progress, 1000, 500, 1500))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(reports) == 0 {
+		t.Fatalf("no progress reported while copying")
+	}
+	if !sort.SliceIsSorted(reports, func(i, j int) bool { return reports[i] < reports[j] }) {
+		t.Errorf("progress went backwards: %v", reports)
+	}
+	for _, current := range reports {
+		if current < 1000 || current > 1500 {
+			t.Errorf("progress %d outside the current file's range", current)
+		}
+	}
+	if reports[len(reports)-1] == 1000 {
+		t.Errorf("progress within the file never reported: %v", reports)
+	}
+
+	copyerr := errors.New("copy failed")
+	err = trackcopyprogress(5*time.Millisecond, func() error { return copyerr }, func() int64 { return 0 }, nil)
+	if err != copyerr {
+		t.Errorf("copy error not returned: %v", err)
+	}
+}
+
+func TestItemProgress(t *testing.T) {
+	if itemprogress(nil, 0, 10, 10) != nil {
+		t.Errorf("progress tracked without a Progress option")
+	}
+
+	var current int64
+	report := itemprogress(func(c int64, total int64) { current = c }, 100, 50, 200)
+
+	tests := map[int64]int64{-1: 100, 0: 100, 25: 125, 50: 150, 80: 150}
+	for copied, expected := range tests {
+		report(copied)
+		if current != expected {
+			t.Errorf("progress for %d bytes copied = %d; want %d", copied, current, expected)
+		}
+	}
+}
+
+func TestHostCopyItems(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "sub"), 0755)
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("12345"), 0644)
+	os.WriteFile(filepath.Join(dir, "sub", "b.txt"), []byte("123"), 0644)
+
+	if _, err := hostcopyitems(dir, false); err == nil {
+		t.Errorf("directory accepted without recursive copy")
+	}
+
+	items, err := hostcopyitems(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 || copytotal(items) != 8 {
+		t.Errorf("unexpected items: %+v", items)
+	}
+
+	items, err = hostcopyitems(filepath.Join(dir, "a.txt"), false)
+	if err != nil || len(items) != 1 || items[0].relpath != "" || items[0].size != 5 {
+		t.Errorf("unexpected items for single file: %+v, %v", items, err)
+	}
+}
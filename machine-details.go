@@ -0,0 +1,282 @@
+package drivervbox
+
+import (
+	"bufio"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kuttiproject/workspace"
+)
+
+// MachineDetails describes the virtual hardware and configuration of
+// a Machine.
+type MachineDetails struct {
+	Name          string
+	QualifiedName string
+	UUID          string
+	OSType        string
+	Groups        []string
+	State         string
+	CPUs          int
+	MemoryMB      int
+	Disks         []DiskDetails
+	NICs          []NICDetails
+	VRDEEnabled   bool
+	VRDEPort      int
+	// Snapshots contains the root snapshots. Child snapshots are nested.
+	Snapshots       []*SnapshotDetails
+	CurrentSnapshot string
+	// K8sVersion is the Kubernetes version of the image the Machine was
+	// created from.
+	K8sVersion string
+	// CreationTime is when the Machine was created. It is the zero
+	// time if not known.
+	CreationTime time.Time
+}
+
+// DiskDetails describes a disk attached to a Machine.
+type DiskDetails struct {
+	Controller   string
+	Port         int
+	Device       int
+	Path         string
+	UUID         string
+	CapacityMB   int64
+	SizeOnDiskMB int64
+}
+
+// NICDetails describes a network adapter of a Machine.
+type NICDetails struct {
+	Index          int
+	Attachment     string
+	Network        string
+	MACAddress     string
+	CableConnected bool
+}
+
+// SnapshotDetails describes a snapshot of a Machine.
+type SnapshotDetails struct {
+	Name        string
+	UUID        string
+	Description string
+	Children    []*SnapshotDetails
+}
+
+var (
+	diskkeypattern, _     = regexp.Compile(`^(.+)-(\d+)-(\d+)$`)
+	snapshotkeypattern, _ = regexp.Compile(`^SnapshotName((?:-\d+)*)$`)
+)
+
+// Details returns the virtual hardware and configuration of the Machine.
+// It does this by running the command:
+//   VBoxManage showvminfo <machinename> --machinereadable
+// and, for each attached disk:
+//   VBoxManage showmediuminfo <diskuuid>
+func (vh *Machine) Details() (*MachineDetails, error) {
+	info, err := vh.driver.vminfo(vh.qname())
+	if err != nil {
+		return nil, err
+	}
+
+	result := parsemachinedetails(info)
+	result.Name = vh.name
+
+	for i := range result.Disks {
+		disk := &result.Disks[i]
+		if disk.UUID == "" {
+			continue
+		}
+
+		output, err := workspace.RunWithResults(
+			vh.driver.vboxmanagepath,
+			"showmediuminfo",
+			disk.UUID,
+		)
+		// Not all media are disks
+		if err != nil {
+			continue
+		}
+
+		disk.CapacityMB, disk.SizeOnDiskMB = parsemediuminfo(output)
+	}
+
+	if k8sversion, ok := vh.getproperty(propK8sVersion); ok {
+		result.K8sVersion = trimpropend(k8sversion)
+	}
+
+	if creationtime, ok := vh.getproperty(propCreationTime); ok {
+		result.CreationTime, _ = time.Parse(time.RFC3339, trimpropend(creationtime))
+	}
+
+	return result, nil
+}
+
+// parsemachinedetails builds MachineDetails from machine-readable VM information.
+func parsemachinedetails(info map[string]string) *MachineDetails {
+	result := &MachineDetails{
+		QualifiedName:   info["name"],
+		UUID:            info["UUID"],
+		OSType:          info["ostype"],
+		State:           info["VMState"],
+		VRDEEnabled:     info["vrde"] == "on",
+		CurrentSnapshot: info["CurrentSnapshotName"],
+	}
+
+	if info["groups"] != "" {
+		result.Groups = strings.Split(info["groups"], ",")
+	}
+	result.CPUs, _ = strconv.Atoi(info["cpus"])
+	result.MemoryMB, _ = strconv.Atoi(info["memory"])
+	result.VRDEPort, _ = strconv.Atoi(info["vrdeport"])
+
+	// Disks are keyed by <controllername>-<port>-<device>
+	controllers := map[string]bool{}
+	for key, value := range info {
+		if strings.HasPrefix(key, "storagecontrollername") {
+			controllers[value] = true
+		}
+	}
+
+	for key, value := range info {
+		match := diskkeypattern.FindStringSubmatch(key)
+		if match == nil || !controllers[match[1]] || value == "none" || value == "emptydrive" {
+			continue
+		}
+
+		port, _ := strconv.Atoi(match[2])
+		device, _ := strconv.Atoi(match[3])
+		result.Disks = append(result.Disks, DiskDetails{
+			Controller: match[1],
+			Port:       port,
+			Device:     device,
+			Path:       value,
+			UUID:       info[fmt.Sprintf("%s-ImageUUID-%s-%s", match[1], match[2], match[3])],
+		})
+	}
+	sort.Slice(result.Disks, func(i, j int) bool {
+		a, b := result.Disks[i], result.Disks[j]
+		if a.Controller != b.Controller {
+			return a.Controller < b.Controller
+		}
+		if a.Port != b.Port {
+			return a.Port < b.Port
+		}
+		return a.Device < b.Device
+	})
+
+	for nic := 1; nic <= maxNICs; nic++ {
+		attachment := info[fmt.Sprintf("nic%d", nic)]
+		if attachment == "" || attachment == "none" {
+			continue
+		}
+
+		var network string
+		switch attachment {
+		case "natnetwork":
+			network = info[fmt.Sprintf("nat-network%d", nic)]
+		case "bridged":
+			network = info[fmt.Sprintf("bridgeadapter%d", nic)]
+		case "hostonly":
+			network = info[fmt.Sprintf("hostonlyadapter%d", nic)]
+		case "intnet":
+			network = info[fmt.Sprintf("intnet%d", nic)]
+		}
+
+		result.NICs = append(result.NICs, NICDetails{
+			Index:          nic,
+			Attachment:     attachment,
+			Network:        network,
+			MACAddress:     formatmacaddress(info[fmt.Sprintf("macaddress%d", nic)]),
+			CableConnected: info[fmt.Sprintf("cableconnected%d", nic)] == "on",
+		})
+	}
+
+	result.Snapshots = parsesnapshots(info)
+
+	return result
+}
+
+// parsesnapshots builds the snapshot tree from machine-readable VM
+// information. Snapshots are keyed by their position in the tree:
+// SnapshotName is the first root snapshot, SnapshotName-1 its first
+// child, SnapshotName-1-1 that child's first child, and so on.
+func parsesnapshots(info map[string]string) []*SnapshotDetails {
+	suffixes := []string{}
+	for key := range info {
+		match := snapshotkeypattern.FindStringSubmatch(key)
+		if match != nil {
+			suffixes = append(suffixes, match[1])
+		}
+	}
+
+	// Sorting by depth, then by position, ensures parents are
+	// seen before children, in order.
+	sort.Slice(suffixes, func(i, j int) bool {
+		a, b := strings.Count(suffixes[i], "-"), strings.Count(suffixes[j], "-")
+		if a != b {
+			return a < b
+		}
+		return snapshotposition(suffixes[i]) < snapshotposition(suffixes[j])
+	})
+
+	result := []*SnapshotDetails{}
+	nodes := map[string]*SnapshotDetails{}
+	for _, suffix := range suffixes {
+		node := &SnapshotDetails{
+			Name:        info["SnapshotName"+suffix],
+			UUID:        info["SnapshotUUID"+suffix],
+			Description: info["SnapshotDescription"+suffix],
+		}
+		nodes[suffix] = node
+
+		if suffix == "" {
+			result = append(result, node)
+			continue
+		}
+
+		parentsuffix := suffix[:strings.LastIndex(suffix, "-")]
+		if parent, ok := nodes[parentsuffix]; ok {
+			parent.Children = append(parent.Children, node)
+		}
+	}
+
+	return result
+}
+
+// snapshotposition returns a sortable key for a snapshot suffix such as
+// "-1-12", so that positions compare numerically.
+func snapshotposition(suffix string) string {
+	parts := strings.Split(suffix, "-")
+	for i, part := range parts {
+		parts[i] = fmt.Sprintf("%08s", part)
+	}
+	return strings.Join(parts, "-")
+}
+
+// parsemediuminfo parses the output of:
+//   VBoxManage showmediuminfo <uuid>
+// and returns the capacity and size on disk, in megabytes.
+func parsemediuminfo(output string) (int64, int64) {
+	var capacity, sizeondisk int64
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), ":")
+		if !found {
+			continue
+		}
+
+		switch strings.TrimSpace(key) {
+		case "Capacity":
+			capacity = parsemetricint(strings.TrimSpace(value))
+		case "Size on disk":
+			sizeondisk = parsemetricint(strings.TrimSpace(value))
+		}
+	}
+
+	return capacity, sizeondisk
+}
@@ -0,0 +1,62 @@
+package drivervbox
+
+import "testing"
+
+const testvminfo = `name="cluster-node1"
+groups="/cluster"
+ostype="Ubuntu (64-bit)"
+UUID="4b0a3b4e-1c2d-4e5f-8a9b-0c1d2e3f4a5b"
+memory=2048
+cpus=2
+VMState="poweroff"
+storagecontrollername0="SATA"
+"SATA-0-0"="/vms/cluster/cluster-node1/disk001.vmdk"
+"SATA-ImageUUID-0-0"="9f8e7d6c-5b4a-3928-1706-f5e4d3c2b1a0"
+"SATA-1-0"="none"
+nic1="natnetwork"
+nat-network1="clusterkuttinet"
+macaddress1="080027AABBCC"
+cableconnected1="on"
+nic2="none"
+vrde="off"
+SnapshotName="base"
+SnapshotUUID="11111111-1111-1111-1111-111111111111"
+SnapshotName-1="first"
+SnapshotUUID-1="22222222-2222-2222-2222-222222222222"
+SnapshotName-2="second"
+SnapshotName-1-1="nested"
+CurrentSnapshotName="nested"
+`
+
+func TestParseMachineDetails(t *testing.T) {
+	details := parsemachinedetails(parsemachinereadable(testvminfo))
+
+	if details.QualifiedName != "cluster-node1" || details.CPUs != 2 || details.MemoryMB != 2048 {
+		t.Errorf("unexpected details: %+v", details)
+	}
+	if len(details.Groups) != 1 || details.Groups[0] != "/cluster" {
+		t.Errorf("unexpected groups: %v", details.Groups)
+	}
+
+	if len(details.Disks) != 1 || details.Disks[0].Path != "/vms/cluster/cluster-node1/disk001.vmdk" ||
+		details.Disks[0].UUID != "9f8e7d6c-5b4a-3928-1706-f5e4d3c2b1a0" {
+		t.Errorf("unexpected disks: %+v", details.Disks)
+	}
+
+	if len(details.NICs) != 1 || details.NICs[0].Network != "clusterkuttinet" ||
+		details.NICs[0].MACAddress != "08:00:27:aa:bb:cc" || !details.NICs[0].CableConnected {
+		t.Errorf("unexpected NICs: %+v", details.NICs)
+	}
+
+	if len(details.Snapshots) != 1 {
+		t.Fatalf("expected 1 root snapshot, got %d", len(details.Snapshots))
+	}
+	root := details.Snapshots[0]
+	if root.Name != "base" || len(root.Children) != 2 ||
+		root.Children[0].Name != "first" || root.Children[1].Name != "second" {
+		t.Fatalf("unexpected snapshot tree: %+v", root)
+	}
+	if len(root.Children[0].Children) != 1 || root.Children[0].Children[0].Name != "nested" {
+		t.Errorf("unexpected nested snapshots: %+v", root.Children[0])
+	}
+}
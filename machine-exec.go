@@ -0,0 +1,237 @@
+package drivervbox
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/kuttiproject/kuttilog"
+)
+
+// ExecOptions control how a command is run inside a Machine by Exec.
+// A nil *ExecOptions is the same as the zero value.
+type ExecOptions struct {
+	// Env contains environment variables for the command, in the
+	// form NAME=VALUE.
+	Env []string
+	// WorkingDir is the working directory for the command in the guest.
+	// If empty, the guest user's default is used.
+	WorkingDir string
+	// Timeout is the maximum time the command may run. Zero means no limit.
+	Timeout time.Duration
+	// Stdin, if not nil, is supplied as the standard input of the command.
+	// Unless StreamStdin is set, this is staged standard input: it is read
+	// completely, and copied into the guest before the command runs. So,
+	// it must reach EOF. See Exec.
+	Stdin io.Reader
+	// StreamStdin connects Stdin directly to VBoxManage, which forwards it
+	// to the command as it is read, so the input may be interactive or
+	// unbounded. Set this only if the installed VirtualBox forwards standard
+	// input through guestcontrol run; otherwise the command sees no input.
+	StreamStdin bool
+}
+
+// ExecResult is the result of running a command inside a Machine.
+type ExecResult struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+}
+
+// vboxmanageerrorprefix is the prefix of errors reported by VBoxManage
+// itself, as opposed to the guest command.
+const vboxmanageerrorprefix = "VBoxManage: error:"
+
+// Exec runs a command inside the Machine, and returns its standard output,
+// standard error and exit code separately.
+// It does this by running the command:
+//   VBoxManage guestcontrol <machinename> --username <username> --password <password> run [--putenv <NAME=VALUE>]... [--cwd <dir>] [--timeout <milliseconds>] --wait-stdout --wait-stderr -- <execpath> <args>
+// This requires Virtual Machine Additions to be running in the guest operating
+// system, and the guest OS should be fully booted up.
+// VBoxManage guestcontrol run cannot be relied on to forward its own standard
+// input to the guest process, in any VirtualBox version supported by this
+// driver (7.1 and above). So, unless options.StreamStdin is set, options.Stdin
+// is staged: it is copied, as CopyTo does, into a private directory created in
+// the guest by running:
+//   mktemp -d /tmp/kutti-stdin-XXXXXXXX
+// and the command is run through /bin/sh with its standard input redirected
+// from a file there. mktemp creates the directory with mode 0700, so only the
+// guest user can read the input. The directory is removed afterwards.
+// A non-zero exit code from the command is not treated as an error. An error is
+// returned only if the command could not be run, or timed out.
+func (vh *Machine) Exec(options *ExecOptions, execpath string, args ...string) (*ExecResult, error) {
+	if options == nil {
+		options = &ExecOptions{}
+	}
+
+	if options.Stdin != nil && !options.StreamStdin {
+		guestdir, err := vh.stageexecinput(options.Stdin)
+		if err != nil {
+			return nil, err
+		}
+		defer vh.removeguestdir(guestdir)
+
+		execpath, args = stdinwrapper(path.Join(guestdir, execInputFile), execpath, args)
+	}
+
+	params := execparams(vh.guestcontrolparams("run"), options, execpath, args)
+
+	// The guest enforces the timeout. The host-side deadline is a
+	// safety net in case VBoxManage itself hangs.
+	ctx := context.Background()
+	if options.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, options.Timeout+30*time.Second)
+		defer cancel()
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, vh.driver.vboxmanagepath, params...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if options.StreamStdin {
+		cmd.Stdin = options.Stdin
+	}
+
+	err := cmd.Run()
+
+	result := &ExecResult{
+		Stdout: stdout.String(),
+		Stderr: stderr.String(),
+	}
+
+	if ctx.Err() == context.DeadlineExceeded {
+		return result, fmt.Errorf("command %s on host '%s' timed out after %v", execpath, vh.name, options.Timeout)
+	}
+
+	result.ExitCode, err = execexitcode(err, result.Stderr)
+	if err != nil {
+		return result, fmt.Errorf("could not run command %s on host '%s': %v", execpath, vh.name, err)
+	}
+
+	return result, nil
+}
+
+// execparams returns the VBoxManage parameters for running a command,
+// following the guestcontrol parameters.
+func execparams(guestcontrolparams []string, options *ExecOptions, execpath string, args []string) []string {
+	params := append([]string{}, guestcontrolparams...)
+	for _, envvar := range options.Env {
+		params = append(params, "--putenv", envvar)
+	}
+	if options.WorkingDir != "" {
+		params = append(params, "--cwd", options.WorkingDir)
+	}
+	if options.Timeout > 0 {
+		params = append(params, "--timeout", fmt.Sprintf("%d", options.Timeout.Milliseconds()))
+	}
+	params = append(params, "--wait-stdout", "--wait-stderr", "--", execpath)
+	params = append(params, args...)
+
+	return params
+}
+
+// execexitcode maps the result of running VBoxManage to the exit code of
+// the guest command. VBoxManage passes on the exit code of the command,
+// but also exits with a non-zero code when it fails itself (host not
+// running, bad credentials, guest timeout). It reports its own failures on
+// standard error, with a distinctive prefix, and these are returned as
+// errors.
+func execexitcode(runerr error, stderr string) (int, error) {
+	exitcode := 0
+	if runerr != nil {
+		var exiterr *exec.ExitError
+		if !errors.As(runerr, &exiterr) {
+			return 0, runerr
+		}
+		exitcode = exiterr.ExitCode()
+	}
+
+	if exitcode != 0 {
+		if idx := strings.Index(stderr, vboxmanageerrorprefix); idx >= 0 {
+			message := strings.TrimSpace(stderr[idx+len(vboxmanageerrorprefix):])
+			if line, _, found := strings.Cut(message, "\n"); found {
+				message = line
+			}
+			return exitcode, errors.New(message)
+		}
+	}
+
+	return exitcode, nil
+}
+
+// stdinwrapper returns a command that runs the specified command with its
+// standard input redirected from a guest file.
+func stdinwrapper(guestinput string, execpath string, args []string) (string, []string) {
+	wrapperargs := []string{
+		"-c",
+		`input="$1"; shift; exec "$@" < "$input"`,
+		"kutti-exec",
+		guestinput,
+		execpath,
+	}
+
+	return "/bin/sh", append(wrapperargs, args...)
+}
+
+// execInputFile is the name of the file holding staged standard input,
+// in the directory returned by stageexecinput.
+const execInputFile = "stdin"
+
+// stageexecinput copies the contents of r to a file in a new private
+// directory in the guest, and returns the path of the directory.
+func (vh *Machine) stageexecinput(r io.Reader) (string, error) {
+	hostfile, err := os.CreateTemp("", "kutti-stdin-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(hostfile.Name())
+
+	_, err = io.Copy(hostfile, r)
+	closeerr := hostfile.Close()
+	if err == nil {
+		err = closeerr
+	}
+	if err != nil {
+		return "", fmt.Errorf("could not read standard input for host '%s': %v", vh.name, err)
+	}
+
+	result, err := vh.Exec(nil, "/bin/mktemp", "-d", "/tmp/kutti-stdin-XXXXXXXX")
+	if err == nil && result.ExitCode != 0 {
+		err = fmt.Errorf("mktemp exited with code %d: %s", result.ExitCode, strings.TrimSpace(result.Stderr))
+	}
+	if err != nil {
+		return "", fmt.Errorf("could not create directory for standard input on host '%s': %v", vh.name, err)
+	}
+
+	guestdir := strings.TrimSpace(result.Stdout)
+	if !strings.HasPrefix(guestdir, "/tmp/kutti-stdin-") {
+		return "", fmt.Errorf("could not create directory for standard input on host '%s': unexpected output '%s'", vh.name, guestdir)
+	}
+
+	err = vh.CopyTo(hostfile.Name(), path.Join(guestdir, execInputFile), nil)
+	if err != nil {
+		vh.removeguestdir(guestdir)
+		return "", err
+	}
+
+	return guestdir, nil
+}
+
+// removeguestdir removes a directory in the guest, logging any failure.
+func (vh *Machine) removeguestdir(guestdir string) {
+	result, err := vh.Exec(nil, "/bin/rm", "-rf", guestdir)
+	if err == nil && result.ExitCode != 0 {
+		err = fmt.Errorf("rm exited with code %d: %s", result.ExitCode, strings.TrimSpace(result.Stderr))
+	}
+	if err != nil {
+		kuttilog.Printf(kuttilog.Debug, "could not remove %s on host '%s': %v", guestdir, vh.name, err)
+	}
+}
@@ -0,0 +1,183 @@
+package drivervbox
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExecParams(t *testing.T) {
+	options := &ExecOptions{
+		Env:        []string{"KUBECONFIG=/etc/kubernetes/admin.conf", "A=b c"},
+		WorkingDir: "/home/kutti",
+		Timeout:    90 * time.Second,
+	}
+
+	guestcontrol := []string{"guestcontrol", "zang-node1", "run"}
+	result := execparams(guestcontrol, options, "/usr/bin/kubectl", []string{"get", "nodes"})
+	expected := []string{
+		"guestcontrol", "zang-node1", "run",
+		"--putenv", "KUBECONFIG=/etc/kubernetes/admin.conf",
+		"--putenv", "A=b c",
+		"--cwd", "/home/kutti",
+		"--timeout", "90000",
+		"--wait-stdout", "--wait-stderr", "--", "/usr/bin/kubectl", "get", "nodes",
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("unexpected params:\n got %q\nwant %q", result, expected)
+	}
+
+	result = execparams(guestcontrol, &ExecOptions{}, "/bin/true", nil)
+	expected = []string{"guestcontrol", "zang-node1", "run", "--wait-stdout", "--wait-stderr", "--", "/bin/true"}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("unexpected params with no options:\n got %q\nwant %q", result, expected)
+	}
+	if len(guestcontrol) != 3 {
+		t.Errorf("guestcontrol params modified")
+	}
+}
+
+func TestStdinWrapper(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs /bin/sh")
+	}
+
+	input := filepath.Join(t.TempDir(), "stdin")
+	if err := os.WriteFile(input, []byte("hello from stdin"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The wrapper must run unchanged under the host shell too
+	execpath, args := stdinwrapper(input, "/bin/sh", []string{"-c", `cat; echo " $0 $1"`, "first arg", "second"})
+	output, err := exec.Command(execpath, args...).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(output) != "hello from stdin first arg second\n" {
+		t.Errorf("unexpected output %q", output)
+	}
+}
+
+func writefakevboxmanage(t *testing.T, script string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("needs /bin/sh")
+	}
+
+	scriptpath := filepath.Join(t.TempDir(), "VBoxManage")
+	if err := os.WriteFile(scriptpath, []byte("#!/bin/sh\n"+script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return scriptpath
+}
+
+func TestExecExitCodes(t *testing.T) {
+	tests := []struct {
+		name       string
+		script     string
+		exitcode   int
+		stdout     string
+		shouldfail bool
+	}{
+		{"success", "echo out; exit 0", 0, "out\n", false},
+		{"guest failure", "echo out; echo oops >&2; exit 3", 3, "out\n", false},
+		{"vboxmanage failure", "echo 'VBoxManage: error: Machine \"zang-node1\" is not running' >&2; exit 1", 1, "", true},
+	}
+
+	for _, test := range tests {
+		vh := &Machine{
+			driver:      &Driver{vboxmanagepath: writefakevboxmanage(t, test.script)},
+			name:        "node1",
+			clustername: "zang",
+		}
+
+		result, err := vh.Exec(nil, "/bin/true")
+		if (err != nil) != test.shouldfail {
+			t.Errorf("%s: error %v; want failure %v", test.name, err, test.shouldfail)
+		}
+		if result == nil || result.ExitCode != test.exitcode || result.Stdout != test.stdout {
+			t.Errorf("%s: unexpected result %+v", test.name, result)
+		}
+		if test.shouldfail && err != nil && !strings.Contains(err.Error(), "is not running") {
+			t.Errorf("%s: VBoxManage message not reported: %v", test.name, err)
+		}
+	}
+}
+
+func TestExecArguments(t *testing.T) {
+	vh := &Machine{
+		driver:      &Driver{vboxmanagepath: writefakevboxmanage(t, `for arg in "$@"; do echo "$arg"; done`)},
+		name:        "node1",
+		clustername: "zang",
+	}
+
+	result, err := vh.Exec(&ExecOptions{WorkingDir: "/tmp"}, "/usr/bin/echo", "a b", "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(result.Stdout), "\n")
+	expected := execparams(vh.guestcontrolparams("run"), &ExecOptions{WorkingDir: "/tmp"}, "/usr/bin/echo", []string{"a b", "c"})
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("VBoxManage received:\n %q\nwant %q", lines, expected)
+	}
+}
+
+func TestExecStagedStdin(t *testing.T) {
+	logpath := filepath.Join(t.TempDir(), "calls")
+	vh := &Machine{
+		driver: &Driver{vboxmanagepath: writefakevboxmanage(t, `echo "$*" >> '`+logpath+`'
+case "$*" in
+	*mktemp*) echo /tmp/kutti-stdin-abcd1234 ;;
+esac`)},
+		name:        "node1",
+		clustername: "zang",
+	}
+
+	_, err := vh.Exec(&ExecOptions{Stdin: strings.NewReader("input")}, "/usr/bin/cat")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(logpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := string(data)
+
+	expected := []string{
+		"run --wait-stdout --wait-stderr -- /bin/mktemp -d /tmp/kutti-stdin-XXXXXXXX",
+		"/tmp/kutti-stdin-abcd1234/stdin /usr/bin/cat",
+		"run --wait-stdout --wait-stderr -- /bin/rm -rf /tmp/kutti-stdin-abcd1234",
+	}
+	position := 0
+	for _, call := range expected {
+		index := strings.Index(calls[position:], call)
+		if index < 0 {
+			t.Fatalf("expected call containing %q after position %d, got:\n%s", call, position, calls)
+		}
+		position += index + len(call)
+	}
+}
+
+func TestExecStreamStdin(t *testing.T) {
+	// The fake VBoxManage echoes its standard input, as a guest cat would
+	vh := &Machine{
+		driver:      &Driver{vboxmanagepath: writefakevboxmanage(t, "cat")},
+		name:        "node1",
+		clustername: "zang",
+	}
+
+	result, err := vh.Exec(&ExecOptions{Stdin: strings.NewReader("streamed input"), StreamStdin: true}, "/usr/bin/cat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Stdout != "streamed input" {
+		t.Errorf("standard input not streamed: got %q", result.Stdout)
+	}
+}
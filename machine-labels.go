@@ -0,0 +1,204 @@
+package drivervbox
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var labelkeypattern, _ = regexp.Compile(`^[A-Za-z0-9]([A-Za-z0-9._-]*[A-Za-z0-9])?$`)
+
+func checklabelkey(key string) error {
+	if !labelkeypattern.MatchString(key) {
+		return fmt.Errorf("invalid label key '%s'", key)
+	}
+	return nil
+}
+
+// checklabelvalue rejects empty label values. Setting a guest property to
+// an empty value deletes it, so an empty label could not be stored.
+func checklabelvalue(key string, value string) error {
+	if value == "" {
+		return fmt.Errorf("label '%s' has an empty value. Use RemoveLabel to remove a label", key)
+	}
+	return nil
+}
+
+// SetLabel sets a user label on the Machine.
+// Label keys may contain letters, digits, '.', '_' and '-', and must start
+// and end with a letter or digit. Label values cannot be empty, because
+// setting a guest property to an empty value removes it.
+// It does this by running the command:
+//   VBoxManage guestproperty set <machinename> /kutti/Labels/<key> <value>
+func (vh *Machine) SetLabel(key string, value string) error {
+	err := checklabelkey(key)
+	if err != nil {
+		return err
+	}
+
+	err = checklabelvalue(key, value)
+	if err != nil {
+		return err
+	}
+
+	err = vh.setproperty(propLabelsPrefix+key, value)
+	if err != nil {
+		return err
+	}
+
+	if vh.labels == nil {
+		vh.labels = map[string]string{}
+	}
+	vh.labels[key] = value
+	return nil
+}
+
+// RemoveLabel removes a user label from the Machine.
+// It does this by running the command:
+//   VBoxManage guestproperty unset <machinename> /kutti/Labels/<key>
+func (vh *Machine) RemoveLabel(key string) error {
+	err := checklabelkey(key)
+	if err != nil {
+		return err
+	}
+
+	err = vh.unsetproperty(propLabelsPrefix + key)
+	if err != nil {
+		return err
+	}
+
+	delete(vh.labels, key)
+	return nil
+}
+
+// Label returns the value of a user label on the Machine, and whether
+// the label is present.
+func (vh *Machine) Label(key string) (string, bool) {
+	value, ok := vh.labels[key]
+	return value, ok
+}
+
+// Labels returns a copy of all user labels on the Machine.
+func (vh *Machine) Labels() map[string]string {
+	result := make(map[string]string, len(vh.labels))
+	for key, value := range vh.labels {
+		result[key] = value
+	}
+	return result
+}
+
+// labelrequirement is one comma-separated term of a label selector.
+type labelrequirement struct {
+	key      string
+	value    string
+	operator string
+}
+
+// parselabelselector parses a selector in the format:
+//   key1=value1,key2!=value2,key3,!key4
+// A bare key requires the label to be present, and a key prefixed with
+// '!' requires it to be absent. Since labels cannot have empty values,
+// terms such as "key=" are rejected. An empty selector has no requirements.
+func parselabelselector(selector string) ([]labelrequirement, error) {
+	result := []labelrequirement{}
+
+	for _, term := range strings.Split(selector, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+
+		var requirement labelrequirement
+		if key, value, found := strings.Cut(term, "!="); found {
+			requirement = labelrequirement{key: key, value: value, operator: "!="}
+		} else if key, value, found := strings.Cut(term, "="); found {
+			requirement = labelrequirement{key: key, value: value, operator: "="}
+		} else if key, found := strings.CutPrefix(term, "!"); found {
+			requirement = labelrequirement{key: key, operator: "!"}
+		} else {
+			requirement = labelrequirement{key: term, operator: ""}
+		}
+
+		requirement.key = strings.TrimSpace(requirement.key)
+		requirement.value = strings.TrimSpace(requirement.value)
+		err := checklabelkey(requirement.key)
+		if err == nil && (requirement.operator == "=" || requirement.operator == "!=") {
+			err = checklabelvalue(requirement.key, requirement.value)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid label selector '%s': %v", selector, err)
+		}
+
+		result = append(result, requirement)
+	}
+
+	return result, nil
+}
+
+func matcheslabels(labels map[string]string, requirements []labelrequirement) bool {
+	for _, requirement := range requirements {
+		value, ok := labels[requirement.key]
+
+		switch requirement.operator {
+		case "=":
+			if !ok || value != requirement.value {
+				return false
+			}
+		case "!=":
+			if ok && value == requirement.value {
+				return false
+			}
+		case "!":
+			if ok {
+				return false
+			}
+		default:
+			if !ok {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// FindMachines returns the machines in a cluster whose user labels match
+// the specified selector, sorted by name. The selector is in the format:
+//   key1=value1,key2!=value2,key3,!key4
+// where a bare key requires the label to be present, and a key prefixed
+// with '!' requires it to be absent. An empty selector matches all machines.
+// Label values cannot be empty, so terms such as "key=" are rejected.
+func (vd *Driver) FindMachines(clustername string, selector string) ([]*Machine, error) {
+	if !vd.validate() {
+		return nil, vd
+	}
+
+	requirements, err := parselabelselector(selector)
+	if err != nil {
+		return nil, err
+	}
+
+	machines, err := vd.clustermachines(clustername)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []*Machine{}
+	for _, machine := range machines {
+		err = machine.get()
+		if err != nil {
+			continue
+		}
+
+		if matcheslabels(machine.labels, requirements) {
+			result = append(result, machine)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].name < result[j].name
+	})
+
+	return result, nil
+}
@@ -0,0 +1,94 @@
+package drivervbox
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLabelSelector(t *testing.T) {
+	tests := []struct {
+		selector string
+		expected []labelrequirement
+		valid    bool
+	}{
+		{"", []labelrequirement{}, true},
+		{" , ", []labelrequirement{}, true},
+		{"role=worker", []labelrequirement{{key: "role", value: "worker", operator: "="}}, true},
+		{
+			"role!=worker, owner ,!gpu",
+			[]labelrequirement{
+				{key: "role", value: "worker", operator: "!="},
+				{key: "owner", operator: ""},
+				{key: "gpu", operator: "!"},
+			},
+			true,
+		},
+		{"zone = a", []labelrequirement{{key: "zone", value: "a", operator: "="}}, true},
+		{"role=", nil, false},
+		{"role!=", nil, false},
+		{"=worker", nil, false},
+		{"!", nil, false},
+		{"bad key=x", nil, false},
+		{"-role=x", nil, false},
+	}
+
+	for _, test := range tests {
+		result, err := parselabelselector(test.selector)
+		if (err == nil) != test.valid {
+			t.Errorf("parselabelselector(%q) error %v; want valid %v", test.selector, err, test.valid)
+			continue
+		}
+		if test.valid && !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("parselabelselector(%q) = %+v; want %+v", test.selector, result, test.expected)
+		}
+	}
+}
+
+func TestMatchesLabels(t *testing.T) {
+	labels := map[string]string{"role": "worker", "owner": "team-a"}
+
+	tests := []struct {
+		selector string
+		expected bool
+	}{
+		{"", true},
+		{"role=worker", true},
+		{"role=control-plane", false},
+		{"role!=control-plane", true},
+		{"role!=worker", false},
+		{"missing!=x", true},
+		{"missing=x", false},
+		{"owner", true},
+		{"missing", false},
+		{"!missing", true},
+		{"!owner", false},
+		{"role=worker,owner=team-a,!gpu", true},
+		{"role=worker,owner=team-b", false},
+	}
+
+	for _, test := range tests {
+		requirements, err := parselabelselector(test.selector)
+		if err != nil {
+			t.Fatalf("parselabelselector(%q): %v", test.selector, err)
+		}
+
+		if result := matcheslabels(labels, requirements); result != test.expected {
+			t.Errorf("matcheslabels(%q) = %v; want %v", test.selector, result, test.expected)
+		}
+	}
+
+	if !matcheslabels(nil, nil) {
+		t.Errorf("machine without labels does not match empty selector")
+	}
+}
+
+func TestSetLabelValidation(t *testing.T) {
+	vh := &Machine{driver: &Driver{}, name: "node1", clustername: "zang"}
+
+	if err := vh.SetLabel("role", ""); err == nil {
+		t.Errorf("empty label value accepted")
+	}
+	if err := vh.SetLabel("bad key", "x"); err == nil {
+		t.Errorf("invalid label key accepted")
+	}
+}
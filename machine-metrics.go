@@ -0,0 +1,252 @@
+package drivervbox
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kuttiproject/kuttilog"
+	"github.com/kuttiproject/workspace"
+)
+
+// Names of VirtualBox performance metrics collected for machines.
+const (
+	metricCPULoadUser        = "CPU/Load/User"
+	metricCPULoadKernel      = "CPU/Load/Kernel"
+	metricRAMUsed            = "RAM/Usage/Used"
+	metricDiskUsed           = "Disk/Usage/Used"
+	metricNetRx              = "Net/Rate/Rx"
+	metricNetTx              = "Net/Rate/Tx"
+	metricGuestCPULoadUser   = "Guest/CPU/Load/User"
+	metricGuestCPULoadKernel = "Guest/CPU/Load/Kernel"
+	metricGuestCPULoadIdle   = "Guest/CPU/Load/Idle"
+	metricGuestRAMTotal      = "Guest/RAM/Usage/Total"
+	metricGuestRAMFree       = "Guest/RAM/Usage/Free"
+	metricGuestRAMCache      = "Guest/RAM/Usage/Cache"
+)
+
+var machinemetricnames = []string{
+	metricCPULoadUser,
+	metricCPULoadKernel,
+	metricRAMUsed,
+	metricDiskUsed,
+	metricNetRx,
+	metricNetTx,
+	metricGuestCPULoadUser,
+	metricGuestCPULoadKernel,
+	metricGuestCPULoadIdle,
+	metricGuestRAMTotal,
+	metricGuestRAMFree,
+	metricGuestRAMCache,
+}
+
+// MachineMetrics contains resource usage of a Machine, as sampled by
+// VirtualBox. CPU loads are percentages. RAM and disk values are current
+// usage, and network values are rates averaged over the last sampling
+// period, not cumulative counters. VirtualBox does not provide cumulative
+// network counters as metrics. Guest values require Virtual Machine
+// Additions to be running in the guest, and are zero otherwise.
+type MachineMetrics struct {
+	Timestamp time.Time
+
+	// CPU load of the VM process on the host
+	CPULoadUser   float64
+	CPULoadKernel float64
+	// RAM used by the VM on the host, in kilobytes
+	RAMUsedKB int64
+	// Disk space used by the VM's disk images, in megabytes
+	DiskUsedMB int64
+	// NetRxBytesPerSec is the rate at which the VM received network data
+	// over the last sampling period, in bytes per second. It is not a
+	// cumulative count.
+	NetRxBytesPerSec int64
+	// NetTxBytesPerSec is the rate at which the VM sent network data over
+	// the last sampling period, in bytes per second. It is not a
+	// cumulative count.
+	NetTxBytesPerSec int64
+
+	// CPU load as reported by the guest
+	GuestCPULoadUser   float64
+	GuestCPULoadKernel float64
+	GuestCPULoadIdle   float64
+	// RAM as reported by the guest, in kilobytes
+	GuestRAMTotalKB int64
+	GuestRAMFreeKB  int64
+	GuestRAMCacheKB int64
+}
+
+// EnableMetrics starts collection of performance metrics for the Machine,
+// sampled every periodinseconds seconds. Values are available via Metrics
+// after the first period has passed.
+// It does this by running the command:
+//   VBoxManage metrics setup --period <periodinseconds> --samples 1 <machinename> <metrics>
+func (vh *Machine) EnableMetrics(periodinseconds int) error {
+	if periodinseconds < 1 {
+		periodinseconds = 1
+	}
+
+	output, err := workspace.RunWithResults(
+		vh.driver.vboxmanagepath,
+		"metrics",
+		"setup",
+		"--period",
+		strconv.Itoa(periodinseconds),
+		"--samples",
+		"1",
+		vh.qname(),
+		strings.Join(machinemetricnames, ","),
+	)
+	if err != nil {
+		return fmt.Errorf("could not enable metrics for host '%s': %v:%s", vh.name, err, output)
+	}
+
+	return nil
+}
+
+// Metrics returns the latest performance metrics of the Machine.
+// EnableMetrics must have been called first. VirtualBox then samples the
+// metrics in the background, so the latest sample is queried instead of
+// running VBoxManage metrics collect, which blocks until interrupted.
+// It does this by running the command:
+//   VBoxManage metrics query <machinename> <metrics>
+func (vh *Machine) Metrics() (*MachineMetrics, error) {
+	output, err := workspace.RunWithResults(
+		vh.driver.vboxmanagepath,
+		"metrics",
+		"query",
+		vh.qname(),
+		strings.Join(machinemetricnames, ","),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not query metrics for host '%s': %v:%s", vh.name, err, output)
+	}
+
+	result := parsemetrics(output)
+	result.Timestamp = time.Now()
+	return result, nil
+}
+
+// StreamMetrics enables metrics collection, and then samples the metrics of
+// the Machine at the specified interval, delivering them via the returned
+// channel. Sampling stops, and the channel is closed, when ctx is done.
+// Failed samples are logged and skipped. The interval must be positive.
+func (vh *Machine) StreamMetrics(ctx context.Context, interval time.Duration) (<-chan *MachineMetrics, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("invalid metrics interval %v: must be positive", interval)
+	}
+
+	periodinseconds := int(interval / time.Second)
+	err := vh.EnableMetrics(periodinseconds)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(chan *MachineMetrics)
+	go func() {
+		defer close(result)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			metrics, err := vh.Metrics()
+			if err != nil {
+				kuttilog.Printf(kuttilog.Debug, "%v", err)
+				continue
+			}
+
+			select {
+			case result <- metrics:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return result, nil
+}
+
+// parsemetrics parses the output of:
+//   VBoxManage metrics query <machinename> <metrics>
+// which looks like this:
+//   Object          Metric                                   Values
+//   --------------- ---------------------------------------- --------------------------------------------
+//   cluster-node1   CPU/Load/User                            1.50%
+//   cluster-node1   RAM/Usage/Used                           2097152 kB
+//   cluster-node1   Net/Rate/Rx                              1024 B/s
+// If multiple samples are present, the values are comma-separated, and the
+// last one is used.
+func parsemetrics(output string) *MachineMetrics {
+	result := &MachineMetrics{}
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			continue
+		}
+
+		values := strings.Split(strings.Join(fields[2:], " "), ",")
+		value := strings.TrimSpace(values[len(values)-1])
+
+		switch fields[1] {
+		case metricCPULoadUser:
+			result.CPULoadUser = parsemetricfloat(value)
+		case metricCPULoadKernel:
+			result.CPULoadKernel = parsemetricfloat(value)
+		case metricRAMUsed:
+			result.RAMUsedKB = parsemetricint(value)
+		case metricDiskUsed:
+			result.DiskUsedMB = parsemetricint(value)
+		case metricNetRx:
+			result.NetRxBytesPerSec = parsemetricint(value)
+		case metricNetTx:
+			result.NetTxBytesPerSec = parsemetricint(value)
+		case metricGuestCPULoadUser:
+			result.GuestCPULoadUser = parsemetricfloat(value)
+		case metricGuestCPULoadKernel:
+			result.GuestCPULoadKernel = parsemetricfloat(value)
+		case metricGuestCPULoadIdle:
+			result.GuestCPULoadIdle = parsemetricfloat(value)
+		case metricGuestRAMTotal:
+			result.GuestRAMTotalKB = parsemetricint(value)
+		case metricGuestRAMFree:
+			result.GuestRAMFreeKB = parsemetricint(value)
+		case metricGuestRAMCache:
+			result.GuestRAMCacheKB = parsemetricint(value)
+		}
+	}
+
+	return result
+}
+
+// metricnumber returns the numeric part of a metric value such as
+// "1.50%" or "2097152 kB".
+func metricnumber(value string) string {
+	end := strings.IndexFunc(value, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.' && r != '-'
+	})
+	if end < 0 {
+		return value
+	}
+	return value[:end]
+}
+
+func parsemetricfloat(value string) float64 {
+	result, _ := strconv.ParseFloat(metricnumber(value), 64)
+	return result
+}
+
+func parsemetricint(value string) int64 {
+	result, _ := strconv.ParseInt(metricnumber(value), 10, 64)
+	return result
+}
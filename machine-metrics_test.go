@@ -0,0 +1,40 @@
+package drivervbox
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParseMetrics(t *testing.T) {
+	metrics := parsemetrics(`Object          Metric                                   Values
+--------------- ---------------------------------------- --------------------------------------------
+cluster-node1   CPU/Load/User                            1.50%
+cluster-node1   CPU/Load/Kernel                          0.25%, 0.75%
+cluster-node1   RAM/Usage/Used                           2097152 kB
+cluster-node1   Disk/Usage/Used                          3072 MB
+cluster-node1   Net/Rate/Rx                              1024 B/s
+cluster-node1   Guest/RAM/Usage/Total                    4030236 kB
+`)
+
+	if metrics.CPULoadUser != 1.5 || metrics.CPULoadKernel != 0.75 {
+		t.Errorf("unexpected CPU loads: %v, %v", metrics.CPULoadUser, metrics.CPULoadKernel)
+	}
+	if metrics.RAMUsedKB != 2097152 || metrics.DiskUsedMB != 3072 || metrics.NetRxBytesPerSec != 1024 {
+		t.Errorf("unexpected usage values: %+v", metrics)
+	}
+	if metrics.GuestRAMTotalKB != 4030236 || metrics.GuestRAMFreeKB != 0 {
+		t.Errorf("unexpected guest values: %+v", metrics)
+	}
+}
+
+func TestStreamMetricsInvalidInterval(t *testing.T) {
+	vh := &Machine{driver: &Driver{}, name: "node1", clustername: "zang"}
+
+	for _, interval := range []time.Duration{0, -time.Second} {
+		metrics, err := vh.StreamMetrics(context.Background(), interval)
+		if err == nil || metrics != nil {
+			t.Errorf("StreamMetrics accepted interval %v", interval)
+		}
+	}
+}
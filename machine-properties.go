@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/kuttiproject/drivercore"
 	"github.com/kuttiproject/workspace"
@@ -13,9 +14,22 @@ const (
 	propIPAddress      = "/VirtualBox/GuestInfo/Net/0/V4/IP"
 	propIPAddress2     = "/VirtualBox/GuestInfo/Net/1/V4/IP"
 	propIPAddress3     = "/VirtualBox/GuestInfo/Net/2/V4/IP"
+	propIPv6Address    = "/VirtualBox/GuestInfo/Net/0/V6/IP"
+	propIPv6Address2   = "/VirtualBox/GuestInfo/Net/1/V6/IP"
+	propIPv6Address3   = "/VirtualBox/GuestInfo/Net/2/V6/IP"
 	propLoggedInUsers  = "/VirtualBox/GuestInfo/OS/LoggedInUsers"
 	propSSHAddress     = "/kutti/VMInfo/SSHAddress"
 	propSavedIPAddress = "/kutti/VMInfo/SavedIPAddress"
+
+	propSavedIPv6Address = "/kutti/VMInfo/SavedIPv6Address"
+	propK8sVersion       = "/kutti/VMInfo/K8sVersion"
+	propImageChecksum    = "/kutti/VMInfo/ImageChecksum"
+	propDriverVersion    = "/kutti/VMInfo/DriverVersion"
+	propCreationTime     = "/kutti/VMInfo/CreationTime"
+	propClusterName      = "/kutti/VMInfo/ClusterName"
+	propRole             = "/kutti/VMInfo/Role"
+
+	propLabelsPrefix = "/kutti/Labels/"
 )
 
 var (
@@ -35,27 +49,31 @@ var propMap = map[string]func(*Machine, string){
 	propSavedIPAddress: func(vh *Machine, value string) {
 		vh.savedipaddress = trimpropend(value)
 	},
+	propSavedIPv6Address: func(vh *Machine, value string) {
+		vh.savedipv6address = trimpropend(value)
+	},
+	propK8sVersion: func(vh *Machine, value string) {
+		vh.info.k8sversion = trimpropend(value)
+	},
+	propImageChecksum: func(vh *Machine, value string) {
+		vh.info.imagechecksum = trimpropend(value)
+	},
+	propDriverVersion: func(vh *Machine, value string) {
+		vh.info.driverversion = trimpropend(value)
+	},
+	propCreationTime: func(vh *Machine, value string) {
+		vh.info.creationtime, _ = time.Parse(time.RFC3339, trimpropend(value))
+	},
+	propClusterName: func(vh *Machine, value string) {
+		vh.info.clustername = trimpropend(value)
+	},
+	propRole: func(vh *Machine, value string) {
+		vh.info.role = trimpropend(value)
+	},
 }
 
 func (vh *Machine) getproperty(propname string) (string, bool) {
-	output, err := workspace.RunWithResults(
-		vh.driver.vboxmanagepath,
-		"guestproperty",
-		"get",
-		vh.qname(),
-		propname,
-	)
-
-	// VBoxManage guestproperty gets the hardcoded value "No value set!"
-	// if the property value cannot be retrieved
-	if err != nil || output == "No value set!" || output == "No value set!\n" {
-		return "", false
-	}
-
-	// Output is in the format
-	// Value: <value>
-	// So, 7th rune onwards
-	return output[7:], true
+	return vh.driver.vmproperty(vh.qname(), propname)
 }
 
 func (vh *Machine) setproperty(propname string, value string) error {
@@ -139,5 +157,14 @@ func (vh *Machine) parseProps(propstr string) {
 		if ok {
 			action(vh, record[2])
 		}
+
+		// User labels are not known in advance, so they are
+		// matched by prefix
+		if labelkey, found := strings.CutPrefix(record[1], propLabelsPrefix); found {
+			if vh.labels == nil {
+				vh.labels = map[string]string{}
+			}
+			vh.labels[labelkey] = trimpropend(record[2])
+		}
 	}
 }
@@ -0,0 +1,36 @@
+package drivervbox
+
+import (
+	"fmt"
+
+	"github.com/kuttiproject/workspace"
+)
+
+// TakeSnapshot takes a snapshot of the Machine with the specified name.
+// It does this by running the command:
+//   VBoxManage snapshot <machinename> take <snapshotname> [--live]
+// A running Machine is snapshotted live, without pausing it.
+func (vh *Machine) TakeSnapshot(snapshotname string) error {
+	params := []string{
+		"snapshot",
+		vh.qname(),
+		"take",
+		snapshotname,
+	}
+
+	vmstate, err := vh.vmstate()
+	if err == nil && vmstate == vmStateRunning {
+		params = append(params, "--live")
+	}
+
+	output, err := workspace.RunWithResults(
+		vh.driver.vboxmanagepath,
+		params...,
+	)
+
+	if err != nil {
+		return fmt.Errorf("could not take snapshot %s of host '%s': %v. Output was %s", snapshotname, vh.name, err, output)
+	}
+
+	return nil
+}
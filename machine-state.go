@@ -0,0 +1,228 @@
+package drivervbox
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/kuttiproject/drivercore"
+	"github.com/kuttiproject/kuttilog"
+	"github.com/kuttiproject/workspace"
+)
+
+// Machine statuses specific to this driver, in addition to those
+// defined by drivercore.
+const (
+	// MachineStatusPaused means the Machine is paused in memory.
+	MachineStatusPaused drivercore.MachineStatus = "Paused"
+	// MachineStatusSaved means the Machine state has been saved to disk.
+	MachineStatusSaved drivercore.MachineStatus = "Saved"
+)
+
+// VirtualBox VM states, as reported by showvminfo --machinereadable.
+const (
+	vmStateRunning  = "running"
+	vmStatePaused   = "paused"
+	vmStateSaved    = "saved"
+	vmStatePoweroff = "poweroff"
+	vmStateAborted  = "aborted"
+)
+
+// vmstate returns the VirtualBox state of the Machine.
+// It does this by running the command:
+//   VBoxManage showvminfo <machinename> --machinereadable
+// and reading the VMState value.
+func (vh *Machine) vmstate() (string, error) {
+	info, err := vh.driver.vminfo(vh.qname())
+	if err != nil {
+		return "", err
+	}
+
+	return info["VMState"], nil
+}
+
+func (vh *Machine) controlvm(operation string, description string) error {
+	output, err := workspace.RunWithResults(
+		vh.driver.vboxmanagepath,
+		"controlvm",
+		vh.qname(),
+		operation,
+	)
+
+	if err != nil {
+		return fmt.Errorf("could not %s the host '%s': %v. Output was %s", description, vh.name, err, output)
+	}
+
+	return nil
+}
+
+// Pause pauses a running Machine, keeping its state in memory.
+// It does this by running the command:
+//   VBoxManage controlvm <machinename> pause
+// This operation will set the status to MachineStatusPaused.
+func (vh *Machine) Pause() error {
+	err := vh.controlvm("pause", "pause")
+	if err != nil {
+		return err
+	}
+
+	vh.status = MachineStatusPaused
+	return nil
+}
+
+// Resume resumes a paused Machine.
+// It does this by running the command:
+//   VBoxManage controlvm <machinename> resume
+// This operation will set the status to drivercore.MachineStatusRunning.
+// A Machine whose state was saved with SaveState should be resumed
+// with Start instead.
+func (vh *Machine) Resume() error {
+	err := vh.controlvm("resume", "resume")
+	if err != nil {
+		return err
+	}
+
+	vh.status = drivercore.MachineStatusRunning
+	return nil
+}
+
+// SaveState saves the state of a running or paused Machine to disk, and
+// stops it. The Machine can later be restored with Start.
+// It does this by running the command:
+//   VBoxManage controlvm <machinename> savestate
+// This operation will set the status to MachineStatusSaved.
+func (vh *Machine) SaveState() error {
+	err := vh.controlvm("savestate", "save the state of")
+	if err != nil {
+		return err
+	}
+
+	vh.status = MachineStatusSaved
+	return nil
+}
+
+// Reset performs a hard reset of a running Machine, like pressing the
+// reset button of a physical computer.
+// It does this by running the command:
+//   VBoxManage controlvm <machinename> reset
+// Note that a Machine may not be ready for further operations at the end of this.
+// See WaitForStateChange().
+func (vh *Machine) Reset() error {
+	return vh.controlvm("reset", "reset")
+}
+
+// StopMethod describes how a Machine was stopped by StopAndWait.
+type StopMethod string
+
+// Ways in which StopAndWait can stop a Machine.
+const (
+	// StopMethodNone means the Machine was not running.
+	StopMethodNone StopMethod = "None"
+	// StopMethodGraceful means the Machine shut down in response to
+	// the ACPI power button.
+	StopMethodGraceful StopMethod = "Graceful"
+	// StopMethodForced means the Machine did not shut down in time,
+	// and was powered off.
+	StopMethodForced StopMethod = "Forced"
+)
+
+// stopPollInterval is how often StopAndWait checks the VM state.
+const stopPollInterval = 2 * time.Second
+
+// StopAndWait stops a Machine gracefully, and waits the specified number of
+// seconds for it to shut down. If it has not shut down by then, it is stopped
+// forcibly. The returned StopMethod reports which of these happened.
+// It does this by running the command:
+//   VBoxManage controlvm <machinename> acpipowerbutton
+// and then polling the VMState value from:
+//   VBoxManage showvminfo <machinename> --machinereadable
+// until it becomes poweroff. If the timeout expires, it runs the command:
+//   VBoxManage controlvm <machinename> poweroff
+// This operation will set the status to drivercore.MachineStatusStopped.
+func (vh *Machine) StopAndWait(timeoutinseconds int) (StopMethod, error) {
+	vmstate, err := vh.vmstate()
+	if err != nil {
+		return StopMethodNone, err
+	}
+
+	switch vmstate {
+	case vmStatePoweroff, vmStateAborted, vmStateSaved:
+		return StopMethodNone, nil
+	case vmStatePaused:
+		// A paused machine cannot respond to the power button
+		err = vh.ForceStop()
+		if err != nil {
+			return StopMethodNone, err
+		}
+		return StopMethodForced, nil
+	}
+
+	err = vh.Stop()
+	if err != nil {
+		return StopMethodNone, err
+	}
+
+	deadline := time.Now().Add(time.Duration(timeoutinseconds) * time.Second)
+	for time.Now().Before(deadline) {
+		time.Sleep(stopPollInterval)
+
+		vmstate, err = vh.vmstate()
+		if err != nil {
+			kuttilog.Printf(kuttilog.Debug, "could not get state of host '%s': %v", vh.name, err)
+			continue
+		}
+
+		if vmstate == vmStatePoweroff {
+			vh.status = drivercore.MachineStatusStopped
+			return StopMethodGraceful, nil
+		}
+	}
+
+	kuttilog.Printf(kuttilog.Info, "Host '%s' did not shut down in %v seconds. Powering off...", vh.name, timeoutinseconds)
+	err = vh.ForceStop()
+	if err != nil {
+		return StopMethodNone, err
+	}
+
+	return StopMethodForced, nil
+}
+
+// waitforpoweroff waits the specified number of seconds for a Machine to
+// be powered off. VirtualBox may still hold the session lock of a Machine
+// for a while after controlvm poweroff returns, so operations such as
+// unregistervm must wait for this.
+// It does this by polling the VMState value from:
+//   VBoxManage showvminfo <machinename> --machinereadable
+func (vh *Machine) waitforpoweroff(timeoutinseconds int) error {
+	return pollvmstate(
+		vh.vmstate,
+		func(vmstate string) bool {
+			return vmstate == vmStatePoweroff || vmstate == vmStateAborted
+		},
+		time.Duration(timeoutinseconds)*time.Second,
+		powerOffPollInterval,
+		vh.name,
+	)
+}
+
+// powerOffPollInterval is how often waitforpoweroff checks the VM state.
+const powerOffPollInterval = 500 * time.Millisecond
+
+// pollvmstate calls getstate every interval until done returns true for the
+// state, or the timeout expires. The first check is immediate.
+func pollvmstate(getstate func() (string, error), done func(string) bool, timeout time.Duration, interval time.Duration, machinename string) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		vmstate, err := getstate()
+		if err == nil && done(vmstate) {
+			return nil
+		}
+		if err != nil {
+			kuttilog.Printf(kuttilog.Debug, "could not get state of host '%s': %v", machinename, err)
+		}
+
+		if !time.Now().Before(deadline) {
+			return fmt.Errorf("host '%s' did not reach the expected state in %v. Last state was '%s'", machinename, timeout, vmstate)
+		}
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,52 @@
+package drivervbox
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPollVMState(t *testing.T) {
+	poweredoff := func(vmstate string) bool { return vmstate == vmStatePoweroff }
+
+	// The state only becomes poweroff after a few checks, with a
+	// transient error in between
+	states := []string{vmStateRunning, "", "stopping", vmStatePoweroff}
+	calls := 0
+	getstate := func() (string, error) {
+		state := states[calls]
+		calls++
+		if state == "" {
+			return "", errors.New("session busy")
+		}
+		return state, nil
+	}
+
+	err := pollvmstate(getstate, poweredoff, time.Second, time.Millisecond, "node1")
+	if err != nil {
+		t.Errorf("poll failed: %v", err)
+	}
+	if calls != len(states) {
+		t.Errorf("expected %d checks, got %d", len(states), calls)
+	}
+
+	calls = 0
+	alwaysrunning := func() (string, error) {
+		calls++
+		return vmStateRunning, nil
+	}
+	err = pollvmstate(alwaysrunning, poweredoff, 20*time.Millisecond, 5*time.Millisecond, "node1")
+	if err == nil {
+		t.Errorf("poll succeeded although the state never changed")
+	}
+	if calls < 2 {
+		t.Errorf("expected repeated checks before timing out, got %d", calls)
+	}
+
+	// No wait if already in the expected state
+	start := time.Now()
+	err = pollvmstate(func() (string, error) { return vmStatePoweroff, nil }, poweredoff, time.Second, time.Second, "node1")
+	if err != nil || time.Since(start) > 500*time.Millisecond {
+		t.Errorf("poll did not return immediately: %v after %v", err, time.Since(start))
+	}
+}
@@ -2,8 +2,11 @@ package drivervbox
 
 import (
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/kuttiproject/drivercore"
+	"github.com/kuttiproject/kuttilog"
 	"github.com/kuttiproject/workspace"
 )
 
@@ -13,10 +16,26 @@ type Machine struct {
 
 	name string
 	// netname        string
-	clustername    string
-	savedipaddress string
-	status         drivercore.MachineStatus
-	errormessage   string
+	clustername      string
+	savedipaddress   string
+	savedipv6address string
+	status           drivercore.MachineStatus
+	errormessage     string
+	info             machineinfo
+	labels           map[string]string
+	// vmstatepending is set when the status was derived from guest
+	// properties, and the VirtualBox VM state has not been checked yet.
+	vmstatepending bool
+}
+
+// machineinfo holds details recorded on a Machine when it was created.
+type machineinfo struct {
+	k8sversion    string
+	imagechecksum string
+	driverversion string
+	creationtime  time.Time
+	clustername   string
+	role          string
 }
 
 // Name is the name of the machine.
@@ -24,6 +43,85 @@ func (vh *Machine) Name() string {
 	return vh.name
 }
 
+// K8sVersion returns the Kubernetes version of the image this Machine was
+// created from. It is empty for Machines created by older drivers.
+func (vh *Machine) K8sVersion() string {
+	return vh.info.k8sversion
+}
+
+// ImageChecksum returns the checksum of the image this Machine was created
+// from. It is empty for Machines created by older drivers.
+func (vh *Machine) ImageChecksum() string {
+	return vh.info.imagechecksum
+}
+
+// DriverVersion returns the version of the driver that created this Machine.
+// It is empty for Machines created by older drivers.
+func (vh *Machine) DriverVersion() string {
+	return vh.info.driverversion
+}
+
+// CreationTime returns the time when this Machine was created. It is the
+// zero time for Machines created by older drivers.
+func (vh *Machine) CreationTime() time.Time {
+	return vh.info.creationtime
+}
+
+// ClusterName returns the name of the cluster this Machine belongs to.
+func (vh *Machine) ClusterName() string {
+	if vh.info.clustername != "" {
+		return vh.info.clustername
+	}
+	return vh.clustername
+}
+
+// Role returns the role of this Machine in its cluster, as specified when it
+// was created or by SetRole.
+func (vh *Machine) Role() string {
+	return vh.info.role
+}
+
+// SetRole records the role of this Machine in its cluster.
+// It does this by running the command:
+//   VBoxManage guestproperty set <machinename> /kutti/VMInfo/Role <role>
+func (vh *Machine) SetRole(role string) error {
+	err := vh.setproperty(propRole, role)
+	if err != nil {
+		return err
+	}
+
+	vh.info.role = role
+	return nil
+}
+
+// recordinfo saves the creation details of the Machine as guest properties.
+// Details that are not known are not saved.
+func (vh *Machine) recordinfo() error {
+	properties := [][2]string{
+		{propK8sVersion, vh.info.k8sversion},
+		{propImageChecksum, vh.info.imagechecksum},
+		{propDriverVersion, vh.info.driverversion},
+		{propClusterName, vh.info.clustername},
+		{propRole, vh.info.role},
+	}
+	if !vh.info.creationtime.IsZero() {
+		properties = append(properties, [2]string{propCreationTime, vh.info.creationtime.Format(time.RFC3339)})
+	}
+
+	for _, property := range properties {
+		if property[1] == "" {
+			continue
+		}
+
+		err := vh.setproperty(property[0], property[1])
+		if err != nil {
+			return fmt.Errorf("could not record %s for host %s: %v", property[0], vh.name, err)
+		}
+	}
+
+	return nil
+}
+
 func (vh *Machine) qname() string {
 	return vh.driver.QualifiedMachineName(vh.name, vh.clustername)
 }
@@ -33,11 +131,41 @@ func (vh *Machine) netname() string {
 }
 
 // Status can be drivercore.MachineStatusRunning, drivercore.MachineStatusStopped
-// drivercore.MachineStatusUnknown or drivercore.MachineStatusError.
+// drivercore.MachineStatusUnknown, drivercore.MachineStatusError,
+// MachineStatusPaused or MachineStatusSaved.
+// The paused and saved statuses cannot be told apart from guest properties,
+// so the first call after the Machine is retrieved also runs the command:
+//   VBoxManage showvminfo <machinename> --machinereadable
 func (vh *Machine) Status() drivercore.MachineStatus {
+	if vh.vmstatepending {
+		vh.vmstatepending = false
+		vh.checkvmstate()
+	}
+
 	return vh.status
 }
 
+// checkvmstate updates the status from the VirtualBox VM state. The
+// LoggedInUsers property survives pausing and saving state, so the VM
+// state takes precedence for those.
+func (vh *Machine) checkvmstate() {
+	if vh.status == drivercore.MachineStatusError {
+		return
+	}
+
+	vmstate, err := vh.vmstate()
+	if err != nil {
+		return
+	}
+
+	switch vmstate {
+	case vmStatePaused:
+		vh.status = MachineStatusPaused
+	case vmStateSaved:
+		vh.status = MachineStatusSaved
+	}
+}
+
 // Error returns the last error caused when manipulating this machine.
 // A valid value can be expected only when Status() returns
 // drivercore.MachineStatusError.
@@ -54,6 +182,21 @@ func (vh *Machine) IPAddress() string {
 	return trimpropend(result)
 }
 
+// IPv6Address returns the current IPv6 Address of this Machine.
+// The Machine status has to be Running, and IPv6 has to be enabled on
+// the Machine's network. If the guest reports IPv6 addresses on more than
+// one interface, the first one inside the network's IPv6 prefix is returned.
+func (vh *Machine) IPv6Address() string {
+	ipv6prefix := vh.networkipv6prefix()
+	if ipv6prefix == "" {
+		return ""
+	}
+
+	// These guestproperties are only available if the VM is
+	// running, and has the Virtual Machine additions enabled
+	return vh.findipv6address(ipv6prefix)
+}
+
 // SSHAddress returns the address and port number to SSH into this Machine.
 func (vh *Machine) SSHAddress() string {
 	// This guestproperty is set when the SSH port is forwarded
@@ -88,7 +231,7 @@ func (vh *Machine) Start() error {
 //   VBoxManage controlvm <machinename> acpipowerbutton
 // Note that a Machine may not be ready for further operations at the end of this,
 // and therefore its status will not change.
-// See WaitForStateChange().
+// See StopAndWait() for a way to wait until the Machine has shut down.
 func (vh *Machine) Stop() error {
 	_, err := workspace.RunWithResults(
 		vh.driver.vboxmanagepath,
@@ -232,6 +375,86 @@ func (vh *Machine) UnforwardPort(machineport int) error {
 	return nil
 }
 
+func (vh *Machine) forwardingrulename6(machineport int) string {
+	return fmt.Sprintf("Node %s Port %d IPv6", vh.qname(), machineport)
+}
+
+// ForwardPort6 creates a rule to forward the specified Machine port to the
+// specified physical host port over IPv6. It does this by running the command:
+//   VBoxManage natnetwork modify --netname <networkname> --port-forward-6 <rule>
+// The rule format is the same as for ForwardPort, with the guest IP being the
+// IPv6 address saved when the Machine was created.
+// This driver writes the rule name as "Node <machinename> Port <machineport> IPv6".
+func (vh *Machine) ForwardPort6(hostport int, machineport int) error {
+	ipv6address := vh.savedipv6Address()
+	if ipv6address == "" {
+		return fmt.Errorf(
+			"no IPv6 address saved for node %s. IPv6 may not be enabled on network %s",
+			vh.name,
+			vh.netname(),
+		)
+	}
+
+	forwardingrule := fmt.Sprintf(
+		"%s:tcp:[]:%d:[%s]:%d",
+		vh.forwardingrulename6(machineport),
+		hostport,
+		ipv6address,
+		machineport,
+	)
+
+	_, err := workspace.RunWithResults(
+		vh.driver.vboxmanagepath,
+		"natnetwork",
+		"modify",
+		"--netname",
+		vh.netname(),
+		"--port-forward-6",
+		forwardingrule,
+	)
+
+	if err != nil {
+		return fmt.Errorf(
+			"could not create IPv6 port forwarding rule %s for node %s on network %s: %v",
+			forwardingrule,
+			vh.name,
+			vh.netname(),
+			err,
+		)
+	}
+
+	return nil
+}
+
+// UnforwardPort6 removes the rule which forwarded the specified VM host port
+// over IPv6. It does this by running the command:
+//   VBoxManage natnetwork modify --netname <networkname> --port-forward-6 delete <rulename>
+func (vh *Machine) UnforwardPort6(machineport int) error {
+	rulename := vh.forwardingrulename6(machineport)
+	_, err := workspace.RunWithResults(
+		vh.driver.vboxmanagepath,
+		"natnetwork",
+		"modify",
+		"--netname",
+		vh.netname(),
+		"--port-forward-6",
+		"delete",
+		rulename,
+	)
+
+	if err != nil {
+		return fmt.Errorf(
+			"driver returned error while removing IPv6 port forwarding rule %s for VM %s on network %s: %v",
+			rulename,
+			vh.name,
+			vh.netname(),
+			err,
+		)
+	}
+
+	return nil
+}
+
 // ForwardSSHPort forwards the SSH port of this Machine to the specified
 // physical host port. See ForwardPort() for details.
 func (vh *Machine) ForwardSSHPort(hostport int) error {
@@ -262,7 +485,8 @@ func (vh *Machine) ForwardSSHPort(hostport int) error {
 }
 
 // ImplementsCommand returns true if the driver implements the specified predefined command.
-// The vbox driver implements drivercore.RenameMachine
+// The vbox driver implements drivercore.RenameMachine, and the driver-specific
+// commands SetTimezone, SetDNSServers, AddHostsEntry, RestartKubelet and CollectLogs.
 func (vh *Machine) ImplementsCommand(command drivercore.PredefinedCommand) bool {
 	_, ok := vboxCommands[command]
 	return ok
@@ -299,11 +523,16 @@ func (vh *Machine) get() error {
 	// If machine properties could be retrieved, assume the machine is in
 	// Stopped state. Parsing the properties may change this.
 	vh.status = drivercore.MachineStatusStopped
+	vh.labels = nil
 
 	if output != "" {
 		vh.parseProps(output)
 	}
 
+	// The VirtualBox VM state is only checked if the status is needed.
+	// See Status.
+	vh.vmstatepending = true
+
 	return nil
 }
 
@@ -316,3 +545,72 @@ func (vh *Machine) savedipAddress() string {
 	result, _ := vh.getproperty(propSavedIPAddress)
 	return trimpropend(result)
 }
+
+func (vh *Machine) savedipv6Address() string {
+	// This guestproperty is set when the VM is created, if
+	// IPv6 is enabled
+	if vh.savedipv6address != "" {
+		return vh.savedipv6address
+	}
+
+	result, _ := vh.getproperty(propSavedIPv6Address)
+	return trimpropend(result)
+}
+
+// findipaddress checks up to three interfaces for an IPv4 address that
+// starts with ipNetAddr (192.168.125 by default).
+func (vh *Machine) findipaddress() string {
+	ipprops := []string{propIPAddress, propIPAddress2, propIPAddress3}
+
+	for _, ipprop := range ipprops {
+		ipaddr, present := vh.getproperty(ipprop)
+
+		if present {
+			ipaddr = trimpropend(ipaddr)
+			if ipRegex.MatchString(ipaddr) && strings.HasPrefix(ipaddr, ipNetAddr) {
+				return ipaddr
+			}
+		}
+
+		if kuttilog.V(kuttilog.Debug) {
+			kuttilog.Printf(kuttilog.Debug, "value of property %v is %v, and present is %v.", ipprop, ipaddr, present)
+			kuttilog.Printf(kuttilog.Debug, "Regex match is %v, and prefix match is %v.", ipRegex.MatchString(ipaddr), strings.HasPrefix(ipaddr, ipNetAddr))
+		}
+	}
+
+	return ""
+}
+
+// findipv6address checks up to three interfaces for an IPv6 address
+// inside the specified network prefix.
+func (vh *Machine) findipv6address(ipv6prefix string) string {
+	ipv6props := []string{propIPv6Address, propIPv6Address2, propIPv6Address3}
+
+	for _, ipv6prop := range ipv6props {
+		ipaddr, present := vh.getproperty(ipv6prop)
+		if !present {
+			continue
+		}
+
+		ipaddr = trimpropend(ipaddr)
+		if isnetipv6address(ipaddr, ipv6prefix) {
+			return ipaddr
+		}
+
+		kuttilog.Printf(kuttilog.Debug, "value of property %v is %v, which is not in prefix %v.", ipv6prop, ipaddr, ipv6prefix)
+	}
+
+	return ""
+}
+
+// networkipv6prefix returns the IPv6 prefix of the Machine's NAT network,
+// or an empty string if IPv6 is not enabled on it.
+func (vh *Machine) networkipv6prefix() string {
+	network, err := vh.driver.findnatnetwork(vh.netname())
+	if err != nil || network == nil {
+		kuttilog.Printf(kuttilog.Debug, "Could not read network %s: %v", vh.netname(), err)
+		return ""
+	}
+
+	return network.ipv6Prefix
+}
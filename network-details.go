@@ -0,0 +1,288 @@
+package drivervbox
+
+import (
+	"bufio"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// PortForwardRule is a port forwarding rule on a NAT network.
+type PortForwardRule struct {
+	Name      string
+	Protocol  string
+	HostIP    string
+	HostPort  int
+	GuestIP   string
+	GuestPort int
+	IPv6      bool
+}
+
+// DHCPServerInfo describes the DHCP server associated with a NAT network.
+type DHCPServerInfo struct {
+	Enabled bool
+	IP      string
+	Netmask string
+	LowerIP string
+	UpperIP string
+}
+
+// DHCPLease is an address lease issued by the DHCP server of a NAT network.
+type DHCPLease struct {
+	MachineName string
+	MACAddress  string
+	IPAddress   string
+	State       string
+	Issued      string
+	Expires     string
+}
+
+// Enabled returns true if the network is enabled.
+func (vn *Network) Enabled() bool {
+	return vn.enabled
+}
+
+// Gateway returns the IPv4 gateway address of the network.
+func (vn *Network) Gateway() string {
+	return vn.gateway
+}
+
+// IPv6Default returns true if the network advertises an IPv6 default route.
+func (vn *Network) IPv6Default() bool {
+	return vn.ipv6Default
+}
+
+// DHCPServer returns details of the network's DHCP server, or nil if
+// there is none.
+func (vn *Network) DHCPServer() *DHCPServerInfo {
+	return vn.dhcp
+}
+
+// PortForwards returns the IPv4 and IPv6 port forwarding rules of the network.
+func (vn *Network) PortForwards() []PortForwardRule {
+	return vn.portforwards
+}
+
+// Leases returns the DHCP leases currently held by machines on the network.
+func (vn *Network) Leases() []DHCPLease {
+	return vn.leases
+}
+
+var (
+	forwardrulepattern, _ = regexp.Compile(`^(.*?):(tcp|udp):\[([^\]]*)\]:(\d+):\[([^\]]*)\]:(\d+)$`)
+)
+
+func parseyesno(value string) bool {
+	return strings.EqualFold(value, "yes") || strings.EqualFold(value, "on") || value == "1"
+}
+
+// parseforwardrule parses a rule in the format:
+//   <rule name>:<protocol>:[<host ip>]:<host port>:[<guest ip>]:<guest port>
+func parseforwardrule(rule string, ipv6 bool) (PortForwardRule, bool) {
+	match := forwardrulepattern.FindStringSubmatch(rule)
+	if match == nil {
+		return PortForwardRule{}, false
+	}
+
+	hostport, _ := strconv.Atoi(match[4])
+	guestport, _ := strconv.Atoi(match[6])
+
+	return PortForwardRule{
+		Name:      match[1],
+		Protocol:  match[2],
+		HostIP:    match[3],
+		HostPort:  hostport,
+		GuestIP:   match[5],
+		GuestPort: guestport,
+		IPv6:      ipv6,
+	}, true
+}
+
+// parsenatnetworks parses the output of:
+//   VBoxManage natnetwork list
+// The output looks like this:
+//   NAT Networks:
+//
+//   Name:         clusterkuttinet
+//   Network:      192.168.125.0/24
+//   Gateway:      192.168.125.1
+//   DHCP Server:  Yes
+//   IPv6:         No
+//   IPv6 Prefix:  fd17:625c:f037:2::/64
+//   IPv6 Default: No
+//   Enabled:      Yes
+//   Port-forwarding (ipv4)
+//           Node cluster-node1 Port 22:tcp:[]:10001:[192.168.125.10]:22
+//   loopback mappings (ipv4)
+//           127.0.0.1=2
+//
+//   1 network found
+func parsenatnetworks(output string) []*Network {
+	var (
+		result  []*Network
+		current *Network
+		section string
+		ipv6on  bool
+	)
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			section = ""
+			continue
+		}
+
+		// Indented lines belong to the current section
+		if line[0] == ' ' || line[0] == '\t' {
+			if current == nil {
+				continue
+			}
+			switch section {
+			case "Port-forwarding (ipv4)", "Port-forwarding (ipv6)":
+				rule, ok := parseforwardrule(trimmed, section == "Port-forwarding (ipv6)")
+				if ok {
+					current.portforwards = append(current.portforwards, rule)
+				}
+			}
+			continue
+		}
+
+		key, value, found := strings.Cut(trimmed, ":")
+		if !found || value == "" {
+			section = trimmed
+			continue
+		}
+		section = ""
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "Name":
+			current = &Network{name: value}
+			result = append(result, current)
+			ipv6on = false
+		case "Network":
+			if current != nil {
+				current.netCIDR = value
+			}
+		case "Gateway":
+			if current != nil {
+				current.gateway = value
+			}
+		case "IPv6":
+			ipv6on = parseyesno(value)
+		case "IPv6 Prefix":
+			// VBoxManage reports a prefix even if IPv6 is off
+			if current != nil && ipv6on {
+				current.ipv6Prefix = value
+			}
+		case "IPv6 Default":
+			if current != nil {
+				current.ipv6Default = parseyesno(value)
+			}
+		case "Enabled":
+			if current != nil {
+				current.enabled = parseyesno(value)
+			}
+		}
+	}
+
+	return result
+}
+
+// parsedhcpservers parses the output of:
+//   VBoxManage list dhcpservers
+// and returns server details keyed by network name. Each server
+// is described by a block that starts like this:
+//   NetworkName:    clusterkuttinet
+//   Dhcpd IP:       192.168.125.3
+//   LowerIPAddress: 192.168.125.10
+//   UpperIPAddress: 192.168.125.39
+//   NetworkMask:    255.255.255.0
+//   Enabled:        Yes
+func parsedhcpservers(output string) map[string]*DHCPServerInfo {
+	result := map[string]*DHCPServerInfo{}
+	var current *DHCPServerInfo
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		// Indented lines are option configuration, which we skip
+		if line == "" || line[0] == ' ' || line[0] == '\t' {
+			continue
+		}
+
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "NetworkName":
+			current = &DHCPServerInfo{}
+			result[value] = current
+		case "Dhcpd IP", "IP":
+			if current != nil {
+				current.IP = value
+			}
+		case "LowerIPAddress":
+			if current != nil {
+				current.LowerIP = value
+			}
+		case "UpperIPAddress":
+			if current != nil {
+				current.UpperIP = value
+			}
+		case "NetworkMask":
+			if current != nil {
+				current.Netmask = value
+			}
+		case "Enabled":
+			if current != nil {
+				current.Enabled = parseyesno(value)
+			}
+		}
+	}
+
+	return result
+}
+
+// parsedhcplease parses the output of:
+//   VBoxManage dhcpserver findlease --netname <networkname> --mac-address <mac>
+// which looks like this:
+//   IP Address:  192.168.125.10
+//   MAC Address: 08:00:27:aa:bb:cc
+//   State:       acked
+//   Issued:      2024-01-01T10:00:00Z (1704103200)
+//   Expire:      2024-01-01T10:10:00Z (1704103800)
+//   TTL:         600 sec, currently 444 sec left
+func parsedhcplease(output string) (DHCPLease, bool) {
+	var result DHCPLease
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), ":")
+		if !found {
+			continue
+		}
+		value = strings.TrimSpace(value)
+
+		switch strings.TrimSpace(key) {
+		case "IP Address":
+			result.IPAddress = value
+		case "MAC Address":
+			result.MACAddress = value
+		case "State":
+			result.State = value
+		case "Issued":
+			result.Issued = value
+		case "Expire":
+			result.Expires = value
+		}
+	}
+
+	return result, result.IPAddress != ""
+}
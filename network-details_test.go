@@ -0,0 +1,154 @@
+package drivervbox
+
+import "testing"
+
+const testnatnetworklist = `NAT Networks:
+
+Name:         clusterkuttinet
+Network:      192.168.125.0/24
+Gateway:      192.168.125.1
+DHCP Server:  Yes
+IPv6:         Yes
+IPv6 Prefix:  fd17:625c:f037:2::/64
+IPv6 Default: No
+Enabled:      Yes
+Port-forwarding (ipv4)
+        Node cluster-node1 Port 22:tcp:[]:10001:[192.168.125.10]:22
+Port-forwarding (ipv6)
+        Node cluster-node1 Port 22 IPv6:tcp:[]:10002:[fd17:625c:f037:2::10]:22
+loopback mappings (ipv4)
+        127.0.0.1=2
+
+Name:         otherkuttinet
+Network:      192.168.125.0/24
+Gateway:      192.168.125.1
+DHCP Server:  Yes
+IPv6:         No
+IPv6 Prefix:  fd17:625c:f037:2::/64
+IPv6 Default: No
+Enabled:      No
+
+2 networks found
+`
+
+func TestParseNATNetworks(t *testing.T) {
+	networks := parsenatnetworks(testnatnetworklist)
+	if len(networks) != 2 {
+		t.Fatalf("expected 2 networks, got %d", len(networks))
+	}
+
+	n := networks[0]
+	if n.Name() != "clusterkuttinet" || n.CIDR() != "192.168.125.0/24" || n.Gateway() != "192.168.125.1" {
+		t.Errorf("unexpected network details: %+v", n)
+	}
+	if !n.Enabled() || n.IPv6Prefix() != "fd17:625c:f037:2::/64" || n.IPv6Default() {
+		t.Errorf("unexpected network state: %+v", n)
+	}
+
+	rules := n.PortForwards()
+	if len(rules) != 2 {
+		t.Fatalf("expected 2 port forwarding rules, got %d", len(rules))
+	}
+	if rules[0].Name != "Node cluster-node1 Port 22" || rules[0].HostPort != 10001 ||
+		rules[0].GuestIP != "192.168.125.10" || rules[0].GuestPort != 22 || rules[0].IPv6 {
+		t.Errorf("unexpected IPv4 rule: %+v", rules[0])
+	}
+	if !rules[1].IPv6 || rules[1].GuestIP != "fd17:625c:f037:2::10" {
+		t.Errorf("unexpected IPv6 rule: %+v", rules[1])
+	}
+
+	other := networks[1]
+	if other.Enabled() || other.IPv6Enabled() || len(other.PortForwards()) != 0 {
+		t.Errorf("unexpected details for second network: %+v", other)
+	}
+}
+
+func TestParseDHCPLease(t *testing.T) {
+	lease, ok := parsedhcplease(`IP Address:  192.168.125.10
+MAC Address: 08:00:27:aa:bb:cc
+State:       acked
+Issued:      2024-01-01T10:00:00Z (1704103200)
+Expire:      2024-01-01T10:10:00Z (1704103800)
+TTL:         600 sec, currently 444 sec left
+`)
+	if !ok || lease.IPAddress != "192.168.125.10" || lease.MACAddress != "08:00:27:aa:bb:cc" || lease.State != "acked" {
+		t.Errorf("unexpected lease: %+v", lease)
+	}
+
+	if formatmacaddress("080027AABBCC") != "08:00:27:aa:bb:cc" {
+		t.Errorf("unexpected MAC address format: %s", formatmacaddress("080027AABBCC"))
+	}
+}
+
+const testdhcpserverlist = `NetworkName:    clusterkuttinet
+Dhcpd IP:       192.168.125.3
+LowerIPAddress: 192.168.125.10
+UpperIPAddress: 192.168.125.39
+NetworkMask:    255.255.255.0
+Enabled:        Yes
+Global Configuration:
+    minLeaseTime:     default
+    defaultLeaseTime: default
+    maxLeaseTime:     default
+    Forced options:   None
+    Suppressed opts.: None
+        1/legacy: 255.255.255.0
+Groups:               None
+Individual Configs:   None
+
+NetworkName:    HostInterfaceNetworking-vboxnet0
+Dhcpd IP:       192.168.56.100
+LowerIPAddress: 192.168.56.101
+UpperIPAddress: 192.168.56.254
+NetworkMask:    255.255.255.0
+Enabled:        No
+Global Configuration:
+    minLeaseTime:     default
+Groups:               None
+Individual Configs:   None
+`
+
+func TestParseDHCPServers(t *testing.T) {
+	servers := parsedhcpservers(testdhcpserverlist)
+	if len(servers) != 2 {
+		t.Fatalf("expected 2 DHCP servers, got %d", len(servers))
+	}
+
+	expected := DHCPServerInfo{
+		IP:      "192.168.125.3",
+		LowerIP: "192.168.125.10",
+		UpperIP: "192.168.125.39",
+		Netmask: "255.255.255.0",
+		Enabled: true,
+	}
+	if server := servers["clusterkuttinet"]; server == nil || *server != expected {
+		t.Errorf("unexpected DHCP server: %+v", server)
+	}
+
+	if server := servers["HostInterfaceNetworking-vboxnet0"]; server == nil || server.Enabled || server.IP != "192.168.56.100" {
+		t.Errorf("unexpected details for second DHCP server: %+v", server)
+	}
+
+	if servers := parsedhcpservers(""); len(servers) != 0 {
+		t.Errorf("expected no DHCP servers, got %v", servers)
+	}
+}
+
+func TestNetworkMACAddresses(t *testing.T) {
+	info := parsemachinereadable(testvminfo + `nic3="natnetwork"
+nat-network3="clusterkuttinet"
+macaddress3="080027DDEEFF"
+nic4="natnetwork"
+nat-network4="otherkuttinet"
+macaddress4="080027112233"
+`)
+
+	result := networkmacaddresses(info, "clusterkuttinet")
+	if len(result) != 2 || result[0] != "08:00:27:aa:bb:cc" || result[1] != "08:00:27:dd:ee:ff" {
+		t.Errorf("unexpected MAC addresses: %v", result)
+	}
+
+	if result := networkmacaddresses(info, "missingkuttinet"); len(result) != 0 {
+		t.Errorf("unexpected MAC addresses for unknown network: %v", result)
+	}
+}
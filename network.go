@@ -2,8 +2,17 @@ package drivervbox
 
 // Network implements the VMNetwork interface for VirtualBox.
 type Network struct {
-	name    string
-	netCIDR string
+	name       string
+	netCIDR    string
+	ipv6Prefix string
+
+	// The following are fully populated only by GetNetwork
+	enabled      bool
+	gateway      string
+	ipv6Default  bool
+	dhcp         *DHCPServerInfo
+	portforwards []PortForwardRule
+	leases       []DHCPLease
 }
 
 // Name is the name of the network.
@@ -16,6 +25,17 @@ func (vn *Network) CIDR() string {
 	return vn.netCIDR
 }
 
+// IPv6Prefix is the network's IPv6 prefix. It is empty if IPv6
+// is not enabled on the network.
+func (vn *Network) IPv6Prefix() string {
+	return vn.ipv6Prefix
+}
+
+// IPv6Enabled returns true if IPv6 is enabled on the network.
+func (vn *Network) IPv6Enabled() bool {
+	return vn.ipv6Prefix != ""
+}
+
 // SetCIDR is not implemented for the VirtualBox driver.
 func (vn *Network) SetCIDR(cidr string) {
 	panic("not implemented")